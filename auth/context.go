@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const claimsKey contextKey = 0
+
+// WithClaims returns a copy of ctx carrying the claims of the authenticated
+// caller.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// ClaimsFromContext returns the claims of the authenticated caller, nil when
+// the request was not authenticated.
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsKey).(*Claims)
+	return claims
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	passwordScheme     = "pbkdf2-sha256"
+	passwordIterations = 210000
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+)
+
+// HashPassword returns a salted PBKDF2 hash of password in the form
+// pbkdf2-sha256$iterations$salt$key.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeySize)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// dummyPasswordHash is checked in place of an empty hash.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("")
+	return hash
+})
+
+// CheckPassword reports whether password matches hash. An empty hash, of a
+// user who can not log in or does not exist, never matches but takes as
+// long to check as a wrong password, so that the time of a login does not
+// tell which users exist.
+func CheckPassword(hash string, password string) bool {
+	if hash == "" {
+		checkPassword(dummyPasswordHash(), password)
+		return false
+	}
+	return checkPassword(hash, password)
+}
+
+func checkPassword(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(expected))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
@@ -0,0 +1,35 @@
+// Package blob stores binary objects, such as photos, under string keys.
+package blob
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store is implemented by every blob storage backend. Keys are made of
+// letters, digits, '.', '_' and '-' only.
+type Store interface {
+	// Put writes the content of r under key, replacing any previous blob.
+	Put(key string, r io.Reader) error
+	// Get opens the blob stored under key, ErrNotFound when there is none.
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key, deleting a missing blob is
+	// not an error.
+	Delete(key string) error
+}
+
+// ValidKey reports whether key can be used with a Store.
+func ValidKey(key string) bool {
+	if key == "" || key[0] == '.' || len(key) > 200 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
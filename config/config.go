@@ -12,19 +12,50 @@ import (
 const (
 	defaultTcpAddress = "localhost:5432"
 	defaultConfigFile = "./config.toml"
+	defaultStorage    = "postgres"
 )
 
 type Config struct {
-	HttpPort       string `flag:"http-port" cfg:"http-port"`
-	PgAddress      string `flag:"pg-address" cfg:"pg-address"`
-	PgUsername     string `flag:"pg-username" cfg:"pg-username"`
-	PgPassword     string `flag:"pg-password" cfg:"pg-password"`
-	PgDatabaseName string `flag:"pg-db-name" cfg:"pg-db-name"`
-	PgPoolsize     int    `flag:"pg-poolsize" cfg:"pg-poolsize"`
-	PgReadTimeout  int    `flag:"pg-readtimeout" cfg:"pg-readtimeout"`
-	PgWriteTimeout int    `flag:"pg-writetimeout" cfg:"pg-writetimeout"`
-	PgIdleTimeout  int    `flag:"pg-idletimeout" cfg:"pg-idletimeout"`
-	InitDB         bool
+	HttpPort             string `flag:"http-port" cfg:"http-port"`
+	PgAddress            string `flag:"pg-address" cfg:"pg-address"`
+	PgUsername           string `flag:"pg-username" cfg:"pg-username"`
+	PgPassword           string `flag:"pg-password" cfg:"pg-password"`
+	PgDatabaseName       string `flag:"pg-db-name" cfg:"pg-db-name"`
+	PgPoolsize           int    `flag:"pg-poolsize" cfg:"pg-poolsize"`
+	PgReadTimeout        int    `flag:"pg-readtimeout" cfg:"pg-readtimeout"`
+	PgWriteTimeout       int    `flag:"pg-writetimeout" cfg:"pg-writetimeout"`
+	PgIdleTimeout        int    `flag:"pg-idletimeout" cfg:"pg-idletimeout"`
+	Storage              string `flag:"storage" cfg:"storage"`
+	LegacyStatus         bool   `flag:"legacy-status" cfg:"legacy-status"`
+	DefaultPageSize      int    `flag:"page-size" cfg:"page-size"`
+	MaxPageSize          int    `flag:"max-page-size" cfg:"max-page-size"`
+	ShutdownTimeout      int    `flag:"shutdown-timeout" cfg:"shutdown-timeout"`
+	ShutdownDelay        int    `flag:"shutdown-delay" cfg:"shutdown-delay"`
+	LogLevel             string `flag:"log-level" cfg:"log-level"`
+	SlowQueryMs          int    `flag:"slow-query-ms" cfg:"slow-query-ms"`
+	AuthSecret           string `flag:"auth-secret" cfg:"auth-secret"`
+	AuthDisabled         bool   `flag:"auth-disabled" cfg:"auth-disabled"`
+	AuthTokenTtl         int    `flag:"auth-token-ttl" cfg:"auth-token-ttl"`
+	RateLimit            string `flag:"rate-limit" cfg:"rate-limit"`
+	TrustedProxies       string `flag:"trusted-proxies" cfg:"trusted-proxies"`
+	DailyLikeQuota       int    `flag:"daily-like-quota" cfg:"daily-like-quota"`
+	BlobStorage          string `flag:"blob-storage" cfg:"blob-storage"`
+	BlobDir              string `flag:"blob-dir" cfg:"blob-dir"`
+	PhotoUrlPrefix       string `flag:"photo-url-prefix" cfg:"photo-url-prefix"`
+	PhotoMaxBytes        int    `flag:"photo-max-bytes" cfg:"photo-max-bytes"`
+	MaxPhotos            int    `flag:"max-photos" cfg:"max-photos"`
+	EventBuffer          int    `flag:"event-buffer" cfg:"event-buffer"`
+	WsPingInterval       int    `flag:"ws-ping-interval" cfg:"ws-ping-interval"`
+	EventReplay          int    `flag:"event-replay" cfg:"event-replay"`
+	SseHeartbeatInterval int    `flag:"sse-heartbeat-interval" cfg:"sse-heartbeat-interval"`
+	WebhookPollInterval  int    `flag:"webhook-poll-interval" cfg:"webhook-poll-interval"`
+	WebhookTimeout       int    `flag:"webhook-timeout" cfg:"webhook-timeout"`
+	WebhookMaxAttempts   int    `flag:"webhook-max-attempts" cfg:"webhook-max-attempts"`
+	LocationInterval     int    `flag:"location-interval" cfg:"location-interval"`
+	InitDB               bool
+	// Command holds the positional arguments left after flag parsing, e.g.
+	// ["migrate", "down", "1"].
+	Command []string
 }
 
 func NewConfig() *Config {
@@ -37,6 +68,7 @@ func NewConfig() *Config {
 
 	initDbFlag := flagSet.Lookup("init")
 	config.InitDB = initDbFlag.Value.(flag.Getter).Get().(bool)
+	config.Command = flagSet.Args()
 
 	verbose := flagSet.Lookup("verbose")
 	if verbose != nil && verbose.Value.(flag.Getter).Get().(bool) {
@@ -49,20 +81,123 @@ func NewConfig() *Config {
 		fmt.Printf("pg-readtimeout: %d\n", config.PgReadTimeout)
 		fmt.Printf("pg-writetimeout: %d\n", config.PgWriteTimeout)
 		fmt.Printf("pg-idletimeout: %d\n", config.PgIdleTimeout)
+		fmt.Printf("storage: %s\n", config.Storage)
+		fmt.Printf("legacy-status: %t\n", config.LegacyStatus)
+		fmt.Printf("page-size: %d\n", config.DefaultPageSize)
+		fmt.Printf("max-page-size: %d\n", config.MaxPageSize)
+		fmt.Printf("shutdown-timeout: %d\n", config.ShutdownTimeout)
+		fmt.Printf("shutdown-delay: %d\n", config.ShutdownDelay)
+		fmt.Printf("log-level: %s\n", config.LogLevel)
+		fmt.Printf("slow-query-ms: %d\n", config.SlowQueryMs)
+		fmt.Printf("auth-secret set: %t\n", config.AuthSecret != "")
+		fmt.Printf("auth-disabled: %t\n", config.AuthDisabled)
+		fmt.Printf("auth-token-ttl: %d\n", config.AuthTokenTtl)
+		fmt.Printf("rate-limit: %s\n", config.RateLimit)
+		fmt.Printf("trusted-proxies: %s\n", config.TrustedProxies)
+		fmt.Printf("daily-like-quota: %d\n", config.DailyLikeQuota)
+		fmt.Printf("blob-storage: %s\n", config.BlobStorage)
+		fmt.Printf("blob-dir: %s\n", config.BlobDir)
+		fmt.Printf("photo-url-prefix: %s\n", config.PhotoUrlPrefix)
+		fmt.Printf("photo-max-bytes: %d\n", config.PhotoMaxBytes)
+		fmt.Printf("max-photos: %d\n", config.MaxPhotos)
+		fmt.Printf("event-buffer: %d\n", config.EventBuffer)
+		fmt.Printf("ws-ping-interval: %d\n", config.WsPingInterval)
+		fmt.Printf("event-replay: %d\n", config.EventReplay)
+		fmt.Printf("sse-heartbeat-interval: %d\n", config.SseHeartbeatInterval)
+		fmt.Printf("webhook-poll-interval: %d\n", config.WebhookPollInterval)
+		fmt.Printf("webhook-timeout: %d\n", config.WebhookTimeout)
+		fmt.Printf("webhook-max-attempts: %d\n", config.WebhookMaxAttempts)
+		fmt.Printf("location-interval: %d\n", config.LocationInterval)
 		fmt.Printf("init: %t\n", config.InitDB)
 	}
 	return config
 }
 
+// Validate reports the values the server cannot run with.
+func (c *Config) Validate() error {
+	if c.AuthSecret == "" && !c.AuthDisabled {
+		return fmt.Errorf("auth-secret is required, set auth-disabled to run without authentication in development")
+	}
+	if c.AuthSecret != "" && c.AuthDisabled {
+		return fmt.Errorf("auth-secret and auth-disabled can not be both set")
+	}
+	if c.DefaultPageSize <= 0 {
+		return fmt.Errorf("page-size must be positive, got %d", c.DefaultPageSize)
+	}
+	if c.MaxPageSize < c.DefaultPageSize {
+		return fmt.Errorf("max-page-size must be at least page-size %d, got %d", c.DefaultPageSize, c.MaxPageSize)
+	}
+	if c.EventBuffer <= 0 {
+		return fmt.Errorf("event-buffer must be positive, got %d", c.EventBuffer)
+	}
+	if c.WsPingInterval <= 0 {
+		return fmt.Errorf("ws-ping-interval must be positive, got %d", c.WsPingInterval)
+	}
+	if c.EventReplay < 0 {
+		return fmt.Errorf("event-replay can not be negative, got %d", c.EventReplay)
+	}
+	if c.SseHeartbeatInterval <= 0 {
+		return fmt.Errorf("sse-heartbeat-interval must be positive, got %d", c.SseHeartbeatInterval)
+	}
+	if c.WebhookPollInterval <= 0 {
+		return fmt.Errorf("webhook-poll-interval must be positive, got %d", c.WebhookPollInterval)
+	}
+	if _, err := c.TrustedProxyNets(); err != nil {
+		return err
+	}
+	if c.LocationInterval < 0 {
+		return fmt.Errorf("location-interval can not be negative, got %d", c.LocationInterval)
+	}
+	return nil
+}
+
 func defaultConfig() *Config {
-	return &Config{"80", defaultTcpAddress, "pger", "pger", "pgerdb", 10, 5, 5, 5, false}
+	return &Config{
+		HttpPort:             "80",
+		PgAddress:            defaultTcpAddress,
+		PgUsername:           "pger",
+		PgPassword:           "pger",
+		PgDatabaseName:       "pgerdb",
+		PgPoolsize:           10,
+		PgReadTimeout:        5,
+		PgWriteTimeout:       5,
+		PgIdleTimeout:        5,
+		Storage:              defaultStorage,
+		LegacyStatus:         false,
+		DefaultPageSize:      50,
+		MaxPageSize:          200,
+		ShutdownTimeout:      30,
+		ShutdownDelay:        0,
+		LogLevel:             "info",
+		SlowQueryMs:          500,
+		AuthSecret:           "",
+		AuthDisabled:         false,
+		AuthTokenTtl:         86400,
+		RateLimit:            "*=20:40",
+		TrustedProxies:       "",
+		DailyLikeQuota:       100,
+		BlobStorage:          "local",
+		BlobDir:              "./blobs",
+		PhotoUrlPrefix:       "/photos/",
+		PhotoMaxBytes:        10485760,
+		MaxPhotos:            6,
+		EventBuffer:          64,
+		WsPingInterval:       30,
+		EventReplay:          100,
+		SseHeartbeatInterval: 15,
+		WebhookPollInterval:  2,
+		WebhookTimeout:       10,
+		WebhookMaxAttempts:   10,
+		LocationInterval:     900,
+		InitDB:               false,
+	}
 }
 
 func fileConfig(configFile string) map[string]interface{} {
 	var v map[string]interface{}
 	_, err := toml.DecodeFile(configFile, &v)
-	if err != nil {
-		fmt.Errorf("FATAL: failed to load config file %s, %s", configFile, err.Error())
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("WARNING: failed to load config file %s, %s\n", configFile, err.Error())
 	}
 	return v
 }
@@ -78,8 +213,35 @@ func consoleConfig() *flag.FlagSet {
 	flagSet.Int("pg-readtimeout", 5, "timeout in seconds when reading from postgresql")
 	flagSet.Int("pg-writetimeout", 5, "timeout in seconds when writing to postgresql")
 	flagSet.Int("pg-idletimeout", 5, "the amount of time in seconds after which client closes idle db connections")
+	flagSet.String("storage", defaultStorage, "storage backend, memory or postgres")
+	flagSet.Bool("legacy-status", false, "if set true, always answer with http status 200 and only report the status in the response body, for old app versions")
+	flagSet.Int("page-size", 50, "number of items returned by list endpoints when no limit is given")
+	flagSet.Int("max-page-size", 200, "maximum number of items a client can request from list endpoints")
+	flagSet.Int("shutdown-timeout", 30, "seconds to wait for in-flight requests to finish on shutdown")
+	flagSet.Int("shutdown-delay", 0, "seconds to keep serving after reporting not ready on shutdown, so load balancers stop routing first")
+	flagSet.String("log-level", "info", "minimum level of the log lines, debug, info, warn or error")
+	flagSet.Int("slow-query-ms", 500, "database calls slower than this many milliseconds are logged as warnings")
+	flagSet.String("auth-secret", "", "secret signing the API bearer tokens, required unless -auth-disabled is set")
+	flagSet.Bool("auth-disabled", false, "development only, serve without authentication: callers act as the user of the route or of the user_id query parameter, and nobody is admin")
+	flagSet.Int("auth-token-ttl", 86400, "lifetime in seconds of the bearer tokens issued at login")
+	flagSet.String("rate-limit", "*=20:40", "comma separated ROUTE=RATE:BURST token buckets per caller, ROUTE is a method and a path like \"PUT /users/{userId}/relationships/{otherUserId}\" or * for the other routes, RATE in requests per second")
+	flagSet.String("trusted-proxies", "", "comma separated IP addresses or CIDR ranges of the proxies in front of the server, whose X-Forwarded-For header gives the address of the callers; empty to use the connection address only")
+	flagSet.Int("daily-like-quota", 100, "likes a non premium user can send per UTC day, 0 for no limit")
+	flagSet.String("blob-storage", "local", "storage backend of the photos, only local for now")
+	flagSet.String("blob-dir", "./blobs", "directory of the local blob storage")
+	flagSet.String("photo-url-prefix", "/photos/", "prefix of the photo URLs returned to clients, e.g. a CDN address")
+	flagSet.Int("photo-max-bytes", 10485760, "maximum size in bytes of an uploaded photo")
+	flagSet.Int("max-photos", 6, "maximum number of photos per user")
+	flagSet.Int("event-buffer", 64, "events queued for a real-time connection before it is dropped as too slow")
+	flagSet.Int("ws-ping-interval", 30, "seconds between the pings sent on WebSocket connections, connections silent for twice as long are closed")
+	flagSet.Int("event-replay", 100, "recent events kept per user for clients resuming an event stream, 0 to keep none")
+	flagSet.Int("sse-heartbeat-interval", 15, "seconds between the heartbeat comments sent on event streams")
+	flagSet.Int("webhook-poll-interval", 2, "seconds between two looks for due webhook deliveries")
+	flagSet.Int("webhook-timeout", 10, "seconds a webhook receiver has to answer a delivery")
+	flagSet.Int("webhook-max-attempts", 10, "attempts of a webhook delivery before it is marked failed")
+	flagSet.Int("location-interval", 900, "minimum seconds between two location updates of a user, so that distances can not be measured from many places, 0 for no limit")
 	flagSet.Bool("verbose", false, "print config value")
-	flagSet.Bool("init", false, "if set true, then init db schema and quit. ")
+	flagSet.Bool("init", false, "deprecated, same as the \"migrate up\" command. ")
 
 	return flagSet
 }
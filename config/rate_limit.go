@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultRateLimitRoute is the route of the rate limit applying to every
+// route without a limit of its own.
+const DefaultRateLimitRoute = "*"
+
+// RateLimit is a token bucket refilled with Rate tokens per second and
+// holding at most Burst tokens.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits parses c.RateLimit, comma separated ROUTE=RATE:BURST entries,
+// into the limits by route. ROUTE is a method and a route template without
+// variable patterns, e.g. "PUT /users/{userId}/relationships/{otherUserId}",
+// or DefaultRateLimitRoute.
+func (c *Config) RateLimits() (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, entry := range strings.Split(c.RateLimit, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("bad rate-limit entry %q, expected ROUTE=RATE:BURST", entry)
+		}
+		route := strings.Join(strings.Fields(entry[:i]), " ")
+		parts := strings.Split(entry[i+1:], ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad rate-limit entry %q, expected ROUTE=RATE:BURST", entry)
+		}
+		rate, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("bad rate in rate-limit entry %q", entry)
+		}
+		burst, err := strconv.Atoi(parts[1])
+		if err != nil || burst <= 0 {
+			return nil, fmt.Errorf("bad burst in rate-limit entry %q", entry)
+		}
+		limits[route] = RateLimit{Rate: rate, Burst: burst}
+	}
+	return limits, nil
+}
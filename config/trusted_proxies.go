@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// TrustedProxyNets parses c.TrustedProxies, comma separated IP addresses or
+// CIDR ranges, into networks.
+func (c *Config) TrustedProxyNets() ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, entry := range strings.Split(c.TrustedProxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("bad trusted-proxies entry %q, expected an IP address or a CIDR range", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("bad trusted-proxies entry %q, expected an IP address or a CIDR range", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"github.com/tangyang/simple-http-server/auth"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+	"github.com/tangyang/simple-http-server/service"
+	"github.com/tangyang/simple-http-server/to"
+	"net/http"
+	"strings"
+)
+
+var authService *service.AuthService = &service.AuthService{}
+
+// publicRoutes can be called without a token: signing up and logging in.
+var publicRoutes = map[string]bool{
+	"POST /users":  true,
+	"POST /tokens": true,
+}
+
+func login(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	m, err := parseParameter(r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	name, err := getStringParameter(m, "name")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	password, err := getStringParameter(m, "password")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	token, expiresAt, err := authService.Login(c, name, password)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusCreated, to.NewTokenTo(token, expiresAt))
+}
+
+// profileRoutes can be called by any authenticated user on behalf of
+// someone else: viewing a profile, like listing users.
+var profileRoutes = map[string]bool{
+	"GET /users/{userId:[0-9]+}": true,
+}
+
+// authenticate requires a valid bearer token on every non public route, and
+// puts its claims in the request context. Callers can only act as the user
+// of the userId route variable, unless they are admins or the route is a
+// profile route. Nothing is checked when c.AuthDisabled is set.
+func authenticate(c *config.Config, method string, route string, next http.HandlerFunc) http.HandlerFunc {
+	if c.AuthDisabled || publicRoutes[method+" "+route] {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			status, result := errorResult(r, model.NewUnauthorizedError("Authorization bearer token is required. "))
+			writeResult(c, w, status, result)
+			return
+		}
+		claims, err := authService.Authenticate(c, token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			status, result := errorResult(r, err)
+			writeResult(c, w, status, result)
+			return
+		}
+		if _, ok := routeVars(r)["userId"]; ok && !claims.IsAdmin() && !profileRoutes[method+" "+route] {
+			userId, err := getIdVar(r, "userId")
+			if err != nil {
+				status, result := errorResult(r, err)
+				writeResult(c, w, status, result)
+				return
+			}
+			if id, _ := claims.UserId(); id != userId {
+				status, result := errorResult(r, model.NewForbiddenError("Not allowed to act as user %d. ", userId))
+				writeResult(c, w, status, result)
+				return
+			}
+		}
+		next(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+	}
+}
+
+// queryToken takes the token from the access_token query parameter when the
+// request has no Authorization header.
+func queryToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether the caller holds an admin token, nobody does when
+// authentication is disabled.
+func isAdmin(c *config.Config, r *http.Request) bool {
+	if c.AuthDisabled {
+		return false
+	}
+	claims := auth.ClaimsFromContext(r.Context())
+	return claims != nil && claims.IsAdmin()
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(h[7:])
+	return token, token != ""
+}
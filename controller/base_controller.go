@@ -1,17 +1,224 @@
 package controller
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/logger"
+	"github.com/tangyang/simple-http-server/model"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
-func parseParameter(r *http.Request) map[string]interface{} {
-	result, _ := ioutil.ReadAll(r.Body)
+func parseParameter(r *http.Request) (map[string]interface{}, error) {
+	result, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
-	fmt.Printf("%s\n", result)
-	var f interface{}
-	json.Unmarshal(result, &f)
-	return f.(map[string]interface{})
+	if err != nil {
+		return nil, model.NewValidationError("Fail to read request body. ")
+	}
+	var f map[string]interface{}
+	if err := json.Unmarshal(result, &f); err != nil || f == nil {
+		return nil, model.NewValidationError("Request body must be a JSON object. ")
+	}
+	return f, nil
+}
+
+// getStringParameter returns the string value of key in the parsed body m.
+func getStringParameter(m map[string]interface{}, key string) (string, error) {
+	v, ok := m[key]
+	if !ok {
+		return "", model.NewValidationError("%s parameter is required! ", key)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", model.NewValidationError("%s parameter must be a string! ", key)
+	}
+	return s, nil
+}
+
+// getBoolParameter returns the boolean value of key in the parsed body m.
+func getBoolParameter(m map[string]interface{}, key string) (bool, error) {
+	v, ok := m[key]
+	if !ok {
+		return false, model.NewValidationError("%s parameter is required! ", key)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, model.NewValidationError("%s parameter must be a boolean! ", key)
+	}
+	return b, nil
+}
+
+// getNumberParameter returns the number value of key in the parsed body m.
+func getNumberParameter(m map[string]interface{}, key string) (float64, error) {
+	v, ok := m[key]
+	if !ok {
+		return 0, model.NewValidationError("%s parameter is required! ", key)
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, model.NewValidationError("%s parameter must be a number! ", key)
+	}
+	return f, nil
+}
+
+// getIdListParameter returns the list of ids of key in the parsed body m.
+func getIdListParameter(m map[string]interface{}, key string) ([]int64, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, model.NewValidationError("%s parameter is required! ", key)
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, model.NewValidationError("%s parameter must be a list of ids! ", key)
+	}
+	ids := make([]int64, 0, len(list))
+	for _, item := range list {
+		f, ok := item.(float64)
+		if !ok || f <= 0 || f != float64(int64(f)) {
+			return nil, model.NewValidationError("%s parameter must be a list of ids! ", key)
+		}
+		ids = append(ids, int64(f))
+	}
+	return ids, nil
+}
+
+// getStringListParameter returns the list of strings of key in the parsed
+// body m.
+func getStringListParameter(m map[string]interface{}, key string) ([]string, error) {
+	v, ok := m[key]
+	if !ok {
+		return nil, model.NewValidationError("%s parameter is required! ", key)
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, model.NewValidationError("%s parameter must be a list of strings! ", key)
+	}
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, model.NewValidationError("%s parameter must be a list of strings! ", key)
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+// getOptionalStringParameter is getStringParameter for a key that may be
+// absent, which yields an empty string.
+func getOptionalStringParameter(m map[string]interface{}, key string) (string, error) {
+	if _, ok := m[key]; !ok {
+		return "", nil
+	}
+	return getStringParameter(m, key)
+}
+
+type routeVarsKey struct{}
+
+// withRouteVars moves the route variables into the request context. mux
+// keys them by request, so they would be lost once a middleware replaces
+// the request with r.WithContext.
+func withRouteVars(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), routeVarsKey{}, mux.Vars(r))))
+	}
+}
+
+// routeVars returns the route variables of r.
+func routeVars(r *http.Request) map[string]string {
+	vars, _ := r.Context().Value(routeVarsKey{}).(map[string]string)
+	return vars
+}
+
+// getIdVar returns the route variable name as an id.
+func getIdVar(r *http.Request, name string) (int64, error) {
+	id, err := strconv.ParseInt(routeVars(r)[name], 10, 64)
+	if err != nil {
+		return 0, model.NewValidationError("Bad parameter %s", name)
+	}
+	return id, nil
+}
+
+// newResult returns the status and the response envelope of a successful
+// request.
+func newResult(status int, data interface{}) (int, interface{}) {
+	return status, model.Result{Code: status, Message: "", Data: data}
+}
+
+// newPageResult returns the response envelope of a list request, next is the
+// id the following page starts after, or 0 on the last page.
+func newPageResult(data interface{}, next int64) (int, interface{}) {
+	result := model.Result{Code: http.StatusOK, Message: "", Data: data}
+	if next > 0 {
+		result.NextCursor = encodeCursor(next)
+	}
+	return http.StatusOK, result
+}
+
+// parsePage reads the limit and cursor query parameters.
+func parsePage(c *config.Config, r *http.Request) (model.Page, error) {
+	limit, err := parseLimit(c, r)
+	if err != nil {
+		return model.Page{}, err
+	}
+	page := model.Page{Limit: limit}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		afterId, err := decodeCursor(v)
+		if err != nil {
+			return page, model.NewValidationError("Bad parameter cursor")
+		}
+		page.AfterId = afterId
+	}
+	return page, nil
+}
+
+// parseLimit reads the limit query parameter, which defaults to
+// c.DefaultPageSize and is capped at c.MaxPageSize.
+func parseLimit(c *config.Config, r *http.Request) (int, error) {
+	limit := c.DefaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return 0, model.NewValidationError("Bad parameter limit")
+		}
+	}
+	if limit > c.MaxPageSize {
+		limit = c.MaxPageSize
+	}
+	return limit, nil
+}
+
+// Cursors are opaque to clients, they carry the id of the last row returned.
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || id < 0 {
+		return 0, errors.New("invalid cursor")
+	}
+	return id, nil
+}
+
+// errorResult returns the status and the response envelope for err. The cause
+// of server side failures is logged since the client only gets a generic
+// message, and the request id lets support staff find that log line.
+func errorResult(r *http.Request, err error) (int, interface{}) {
+	result := model.NewErrorResult(err)
+	result.RequestId = logger.RequestId(r.Context())
+	if result.Code >= http.StatusInternalServerError {
+		logger.FromContext(r.Context()).Error("request failed", "error", err.Error())
+	}
+	return result.Code, result
 }
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+	"github.com/tangyang/simple-http-server/service"
+	"github.com/tangyang/simple-http-server/to"
+	"net/http"
+	"strconv"
+)
+
+var candidateService *service.CandidateService = &service.CandidateService{}
+
+func getCandidates(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	limit, err := parseLimit(c, r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	cursor := model.CandidateCursor{Likers: true}
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		cursor, err = decodeCandidateCursor(v)
+		if err != nil {
+			return errorResult(r, model.NewValidationError("Bad parameter cursor"))
+		}
+	}
+	var radiusKm float64
+	if v := r.URL.Query().Get("radius_km"); v != "" {
+		radiusKm, err = strconv.ParseFloat(v, 64)
+		if err != nil || !(radiusKm > 0) {
+			return errorResult(r, model.NewValidationError("Bad parameter radius_km"))
+		}
+	}
+	candidates, next, err := candidateService.GetCandidates(c, userId, cursor, limit, radiusKm)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	tos := to.NewCandidateToArray(candidates)
+	users := make([]*to.UserTo, len(tos))
+	for i := range tos {
+		users[i] = &tos[i].UserTo
+	}
+	if err := withPhotos(c, users...); err != nil {
+		return errorResult(r, err)
+	}
+	result := model.Result{Code: http.StatusOK, Message: "", Data: tos}
+	if next != nil {
+		result.NextCursor = encodeCandidateCursor(*next)
+	}
+	return http.StatusOK, result
+}
+
+func blockUser(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	otherUserId, err := getIdVar(r, "otherUserId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	if _, err := candidateService.Block(c, userId, otherUserId); err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, nil)
+}
+
+func unblockUser(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	otherUserId, err := getIdVar(r, "otherUserId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	b, err := candidateService.Unblock(c, userId, otherUserId)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	if !b {
+		return errorResult(r, model.NewNotFoundError("Block does not exist! "))
+	}
+	return newResult(http.StatusOK, nil)
+}
+
+// Candidate cursors carry the group of the last candidate returned, "l" for
+// the users who like the caller and "o" for the others, and its id.
+func encodeCandidateCursor(cursor model.CandidateCursor) string {
+	group := "o"
+	if cursor.Likers {
+		group = "l"
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(group + strconv.FormatInt(cursor.AfterId, 10)))
+}
+
+func decodeCandidateCursor(s string) (model.CandidateCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) < 2 || (b[0] != 'l' && b[0] != 'o') {
+		return model.CandidateCursor{}, errors.New("invalid cursor")
+	}
+	id, err := strconv.ParseInt(string(b[1:]), 10, 64)
+	if err != nil || id < 0 {
+		return model.CandidateCursor{}, errors.New("invalid cursor")
+	}
+	return model.CandidateCursor{Likers: b[0] == 'l', AfterId: id}, nil
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"github.com/tangyang/simple-http-server/auth"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+	"github.com/tangyang/simple-http-server/service"
+	"github.com/tangyang/simple-http-server/to"
+	"net/http"
+	"strconv"
+)
+
+var chatService *service.ChatService = &service.ChatService{}
+
+func getMatches(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	page, err := parsePage(c, r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	matches, next, err := chatService.GetMatches(c, userId, page)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newPageResult(to.NewMatchToArray(matches, userId), next)
+}
+
+func getMessages(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := callerId(c, r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	matchId, err := getIdVar(r, "matchId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	page, err := parsePage(c, r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	messages, next, conversation, err := chatService.GetMessages(c, userId, matchId, page)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newPageResult(to.NewMessageToArray(messages, conversation), next)
+}
+
+func sendMessage(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := callerId(c, r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	matchId, err := getIdVar(r, "matchId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	m, err := parseParameter(r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	body, err := getStringParameter(m, "body")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	message, conversation, err := chatService.SendMessage(c, userId, matchId, body)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusCreated, to.NewMessageTo(message, conversation))
+}
+
+func markRead(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := callerId(c, r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	matchId, err := getIdVar(r, "matchId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	m, err := parseParameter(r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	messageId, err := getNumberParameter(m, "message_id")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	if messageId <= 0 || messageId != float64(int64(messageId)) {
+		return errorResult(r, model.NewValidationError("Bad parameter message_id"))
+	}
+	if err := chatService.MarkRead(c, userId, matchId, int64(messageId)); err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, nil)
+}
+
+// callerId returns the user acting on a route without a userId variable:
+// the subject of the token, or the user_id query parameter when
+// authentication is disabled with c.AuthDisabled.
+func callerId(c *config.Config, r *http.Request) (int64, error) {
+	if !c.AuthDisabled {
+		claims := auth.ClaimsFromContext(r.Context())
+		if claims == nil {
+			return 0, model.NewUnauthorizedError("Authorization bearer token is required. ")
+		}
+		id, err := claims.UserId()
+		if err != nil {
+			return 0, model.NewForbiddenError("Token does not belong to a user. ")
+		}
+		return id, nil
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, model.NewValidationError("user_id parameter is required! ")
+	}
+	return id, nil
+}
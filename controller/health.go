@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/service"
+	"github.com/tangyang/simple-http-server/to"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ready is 1 while the server accepts traffic; it is flipped to 0 when the
+// server starts shutting down so that load balancers stop routing to it.
+var ready int32 = 1
+
+func SetReady(b bool) {
+	var v int32
+	if b {
+		v = 1
+	}
+	atomic.StoreInt32(&ready, v)
+}
+
+func IsReady() bool {
+	return atomic.LoadInt32(&ready) == 1
+}
+
+var healthService *service.HealthService = &service.HealthService{}
+
+// healthz tells that the process is alive, it does not look at dependencies.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, to.HealthTo{Status: "alive"})
+}
+
+// readyz tells whether the server should receive traffic: it is not shutting
+// down, the storage answers and the schema is migrated.
+func readyz(c *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result := to.ReadinessTo{Accepting: IsReady(), Storage: c.Storage, StorageStatus: "up",
+			LatestSchemaVersion: healthService.LatestSchemaVersion()}
+		ok := result.Accepting
+
+		latency, err := healthService.PingStorage(c)
+		result.StorageLatencyMs = float64(latency) / float64(time.Millisecond)
+		if err != nil {
+			// The probe is not authenticated, the error is only logged.
+			slog.Error("storage is down", "storage", c.Storage, "error", err.Error())
+			ok = false
+			result.StorageStatus = "down"
+		} else {
+			if pool, err := healthService.GetPoolStats(c); err != nil {
+				slog.Warn("fail to get pool stats", "error", err.Error())
+			} else if pool != nil {
+				result.Pool = &to.PoolTo{Size: pool.Size, Requests: pool.Requests, Hits: pool.Hits, Timeouts: pool.Timeouts,
+					TotalConns: pool.TotalConns, FreeConns: pool.FreeConns}
+			}
+			result.SchemaVersion, err = healthService.GetSchemaVersion(c)
+			if err != nil || result.SchemaVersion < result.LatestSchemaVersion {
+				ok = false
+			}
+		}
+
+		status := http.StatusOK
+		result.Status = "ready"
+		if !ok {
+			status = http.StatusServiceUnavailable
+			result.Status = "not ready"
+		}
+		writeJSON(w, status, result)
+	}
+}
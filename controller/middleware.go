@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/tangyang/simple-http-server/logger"
+	"github.com/tangyang/simple-http-server/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const requestIdHeader = "X-Request-ID"
+
+var (
+	httpRequestsTotal = metrics.NewCounterVec("http_requests_total",
+		"Number of HTTP requests, by method, route template and status.", "method", "route", "status")
+	httpRequestDuration = metrics.NewHistogramVec("http_request_duration_seconds",
+		"Latency of HTTP requests, by method, route template and status.", metrics.DefBuckets, "method", "route", "status")
+	httpStreamDuration = metrics.NewHistogramVec("http_stream_duration_seconds",
+		"Duration of the real-time connections, by method, route template and status.",
+		[]float64{1, 10, 60, 300, 900, 1800, 3600, 7200, 14400}, "method", "route", "status")
+)
+
+// statusRecorder remembers the status code and the number of bytes written
+// through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the hijacker and flusher of
+// the original response writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// instrument records the count and latency of requests to a route, the
+// route template keeps the number of series bounded.
+func instrument(method string, route string, next http.HandlerFunc) http.HandlerFunc {
+	return observeRequests(httpRequestDuration, method, route, next)
+}
+
+// instrumentStream is instrument for the real-time routes. Their connections
+// last minutes to hours, so their durations are recorded apart from the
+// request latencies.
+func instrumentStream(method string, route string, next http.HandlerFunc) http.HandlerFunc {
+	return observeRequests(httpStreamDuration, method, route, next)
+}
+
+func observeRequests(duration *metrics.HistogramVec, method string, route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		status := strconv.Itoa(rec.status)
+		httpRequestsTotal.Inc(method, route, status)
+		duration.Observe(time.Since(start).Seconds(), method, route, status)
+	}
+}
+
+// requestId propagates the X-Request-ID header of the request, or generates
+// one, into the request context and the response headers.
+func requestId(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(requestIdHeader, id)
+		next(w, r.WithContext(logger.WithRequestId(r.Context(), id)))
+	}
+}
+
+// validRequestId accepts ids set by clients or proxies as long as they are
+// short and printable, since they end up in logs and headers.
+func validRequestId(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// accessLog logs one line per request once it is served.
+func accessLog(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		logger.FromContext(r.Context()).Info("access",
+			"method", r.Method,
+			"route", route,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"latency_ms", float64(time.Since(start))/float64(time.Millisecond),
+			"bytes", rec.bytes,
+			"remote_addr", r.RemoteAddr)
+	}
+}
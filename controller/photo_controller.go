@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"errors"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/logger"
+	"github.com/tangyang/simple-http-server/model"
+	"github.com/tangyang/simple-http-server/service"
+	"github.com/tangyang/simple-http-server/to"
+	"io"
+	"net/http"
+	"path"
+)
+
+var photoService *service.PhotoService = &service.PhotoService{}
+
+// multipartOverhead is the room left in upload bodies for the multipart
+// boundaries and headers around the photo.
+const multipartOverhead = 64 * 1024
+
+func addPhoto(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(c.PhotoMaxBytes)+multipartOverhead)
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return errorResult(r, model.NewValidationError("Photo can not be larger than %d bytes! ", c.PhotoMaxBytes))
+		}
+		return errorResult(r, model.NewValidationError("photo file is required in a multipart/form-data body! "))
+	}
+	defer file.Close()
+	photo, err := photoService.AddPhoto(c, userId, file)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusCreated, to.NewPhotoTo(photo, c.PhotoUrlPrefix))
+}
+
+func getPhotos(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	photos, err := photoService.GetPhotos(c, userId)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, to.NewPhotoToArray(photos, c.PhotoUrlPrefix))
+}
+
+func reorderPhotos(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	m, err := parseParameter(r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	photoIds, err := getIdListParameter(m, "photo_ids")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	if err := photoService.ReorderPhotos(c, userId, photoIds); err != nil {
+		return errorResult(r, err)
+	}
+	photos, err := photoService.GetPhotos(c, userId)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, to.NewPhotoToArray(photos, c.PhotoUrlPrefix))
+}
+
+func deletePhoto(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	photoId, err := getIdVar(r, "photoId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	if err := photoService.DeletePhoto(c, userId, photoId); err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, nil)
+}
+
+// photoContentTypes maps the extensions of the blob keys to the content
+// type they are served with.
+var photoContentTypes = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+}
+
+// servePhoto serves the images stored under the photo URLs. Keys are random
+// and never reused, so the images can be cached for good.
+func servePhoto(c *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := routeVars(r)["key"]
+		contentType, ok := photoContentTypes[path.Ext(key)]
+		if !ok {
+			status, result := errorResult(r, model.NewNotFoundError("Photo does not exist"))
+			writeResult(c, w, status, result)
+			return
+		}
+		f, err := photoService.OpenPhoto(key)
+		if err != nil {
+			status, result := errorResult(r, err)
+			writeResult(c, w, status, result)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := io.Copy(w, f); err != nil {
+			logger.FromContext(r.Context()).Warn("fail to send photo", "key", key, "error", err.Error())
+		}
+	}
+}
+
+// withPhotos fills in the photos of users with a single lookup.
+func withPhotos(c *config.Config, users ...*to.UserTo) error {
+	userIds := make([]int64, 0, len(users))
+	for _, user := range users {
+		userIds = append(userIds, user.Id)
+	}
+	photos, err := photoService.GetPhotosByUsers(c, userIds)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if len(photos[user.Id]) > 0 {
+			user.Photos = to.NewPhotoToArray(photos[user.Id], c.PhotoUrlPrefix)
+		}
+	}
+	return nil
+}
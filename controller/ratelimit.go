@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/metrics"
+	"github.com/tangyang/simple-http-server/model"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitHeader          = "X-RateLimit-Limit"
+	rateLimitRemainingHeader = "X-RateLimit-Remaining"
+)
+
+var rateLimitedTotal = metrics.NewCounterVec("http_rate_limited_total",
+	"Number of requests refused by the rate limit, by method and route template.", "method", "route")
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter keeps a token bucket per caller of one route. proxies are the
+// trusted proxies, which tell the address of the callers behind them.
+type rateLimiter struct {
+	limit   config.RateLimit
+	proxies []*net.IPNet
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+func newRateLimiter(limit config.RateLimit, proxies []*net.IPNet) *rateLimiter {
+	return &rateLimiter{limit: limit, proxies: proxies, buckets: make(map[string]*tokenBucket)}
+}
+
+// take removes a token from the bucket of key. It reports whether there was
+// one, how many are left and, when there was none, how long until the next.
+func (l *rateLimiter) take(key string, now time.Time) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*l.limit.Rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, 0, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// sweep forgets, at most once a minute, the buckets that are full again so
+// that the map does not keep every caller ever seen.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	refill := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// routeVarPattern matches the pattern of a route variable, e.g. ":[0-9]+"
+// in "{userId:[0-9]+}".
+var routeVarPattern = regexp.MustCompile(`\{(\w+):[^}]*\}`)
+
+// rateLimitRoute returns the name of a route in the rate-limit setting, the
+// method and the route template without variable patterns.
+func rateLimitRoute(method string, route string) string {
+	return method + " " + routeVarPattern.ReplaceAllString(route, "{$1}")
+}
+
+// newRateLimiters returns the limiter of every route and stream, routes
+// without a limit of their own share the settings of the default limit but
+// still get their own buckets.
+func newRateLimiters(c *config.Config) (map[string]*rateLimiter, error) {
+	limits, err := c.RateLimits()
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := c.TrustedProxyNets()
+	if err != nil {
+		return nil, err
+	}
+	limiters := make(map[string]*rateLimiter)
+	eachRoute(func(method string, route string) {
+		name := rateLimitRoute(method, route)
+		limit, ok := limits[name]
+		if !ok {
+			limit, ok = limits[config.DefaultRateLimitRoute]
+		}
+		if ok {
+			limiters[method+" "+route] = newRateLimiter(limit, proxies)
+		}
+		delete(limits, name)
+	})
+	delete(limits, config.DefaultRateLimitRoute)
+	for name := range limits {
+		return nil, fmt.Errorf("unknown route %q in rate-limit", name)
+	}
+	return limiters, nil
+}
+
+// limitRate refuses requests with 429 once the caller spent the tokens of
+// its bucket. It runs before authenticate so that requests with bad tokens
+// are limited too: callers with a valid token are limited by user, others
+// by IP.
+func limitRate(c *config.Config, limiter *rateLimiter, method string, route string, next http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, remaining, wait := limiter.take(limiter.key(c, r), time.Now())
+		w.Header().Set(rateLimitHeader, strconv.Itoa(limiter.limit.Burst))
+		w.Header().Set(rateLimitRemainingHeader, strconv.Itoa(remaining))
+		if !ok {
+			rateLimitedTotal.Inc(method, route)
+			setRetryAfter(w, wait)
+			status, result := errorResult(r, model.NewTooManyRequestsError("Too many requests, retry later. "))
+			writeResult(c, w, status, result)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// key returns the bucket of the caller of r. Only verified tokens count,
+// otherwise a caller could pick a new user for every request.
+func (l *rateLimiter) key(c *config.Config, r *http.Request) string {
+	if token, ok := bearerToken(r); ok && !c.AuthDisabled {
+		if claims, err := authService.Authenticate(c, token); err == nil {
+			return "user:" + claims.Sub
+		}
+	}
+	return "ip:" + clientIp(l.proxies, r)
+}
+
+// clientIp returns the address of the caller of r. Behind trusted proxies it
+// is the last address of the X-Forwarded-For header not added by one of
+// them, the addresses before it can be forged by the caller.
+func clientIp(proxies []*net.IPNet, r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(proxies, host) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		host = addr
+		if !isTrustedProxy(proxies, addr) {
+			break
+		}
+	}
+	return host
+}
+
+func isTrustedProxy(proxies []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	for _, n := range proxies {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// setRetryAfter sets the Retry-After header to wait, in whole seconds.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
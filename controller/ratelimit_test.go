@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// limitedHandler is the handler of GET /users rate limited and authenticated
+// like InitRouters does, with a burst of 2 requests per caller.
+func limitedHandler(t *testing.T, c *config.Config) http.HandlerFunc {
+	proxies, err := c.TrustedProxyNets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := newRateLimiter(config.RateLimit{Rate: 0.001, Burst: 2}, proxies)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	return limitRate(c, limiter, "GET", "/users", authenticate(c, "GET", "/users", ok))
+}
+
+func statuses(h http.HandlerFunc, requests ...*http.Request) []int {
+	codes := []int{}
+	for _, r := range requests {
+		w := httptest.NewRecorder()
+		h(w, r)
+		codes = append(codes, w.Code)
+	}
+	return codes
+}
+
+func newRequest(remoteAddr string, forwardedFor string, token string) *http.Request {
+	r := httptest.NewRequest("GET", "/users", nil)
+	r.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestRateLimitIgnoresForwardedForByDefault(t *testing.T) {
+	c := &config.Config{AuthDisabled: true}
+	if c.TrustedProxies != "" {
+		t.Fatalf("trusted proxies default to %q", c.TrustedProxies)
+	}
+	got := statuses(limitedHandler(t, c),
+		newRequest("192.0.2.1:1000", "198.51.100.1", ""),
+		newRequest("192.0.2.1:1001", "198.51.100.2", ""),
+		newRequest("192.0.2.1:1002", "198.51.100.3", ""),
+		newRequest("192.0.2.2:1000", "", ""))
+	if want := []int{200, 200, 429, 200}; !equalInts(got, want) {
+		t.Errorf("got statuses %v, want %v: a rotating X-Forwarded-For must not give a new bucket", got, want)
+	}
+}
+
+func TestRateLimitTrustedProxies(t *testing.T) {
+	c := &config.Config{AuthDisabled: true, TrustedProxies: "10.0.0.0/8, 192.0.2.9"}
+	got := statuses(limitedHandler(t, c),
+		newRequest("10.1.2.3:1000", "198.51.100.1", ""),
+		newRequest("10.1.2.4:1000", "198.51.100.2", ""),
+		// Addresses before the one added by the proxies are forged.
+		newRequest("10.1.2.3:1001", "203.0.113.1, 198.51.100.1, 192.0.2.9", ""),
+		newRequest("10.1.2.3:1002", "203.0.113.2, 198.51.100.1", ""),
+		// Untrusted peers are limited by their own address.
+		newRequest("192.0.2.1:1000", "198.51.100.3", ""),
+		newRequest("192.0.2.1:1001", "198.51.100.4", ""),
+		newRequest("192.0.2.1:1002", "198.51.100.5", ""))
+	if want := []int{200, 200, 200, 429, 200, 200, 429}; !equalInts(got, want) {
+		t.Errorf("got statuses %v, want %v", got, want)
+	}
+	if ip := clientIp(nil, newRequest("[2001:db8::1]:1000", "198.51.100.1", "")); ip != "2001:db8::1" {
+		t.Errorf("got client %s, want the peer address", ip)
+	}
+	if _, err := (&config.Config{TrustedProxies: "10.0.0.0/33"}).TrustedProxyNets(); err == nil {
+		t.Error("a bad CIDR range was accepted")
+	}
+	if nets, err := (&config.Config{TrustedProxies: "192.0.2.9"}).TrustedProxyNets(); err != nil || !nets[0].Contains(net.ParseIP("192.0.2.9")) || nets[0].Contains(net.ParseIP("192.0.2.10")) {
+		t.Errorf("got networks %v, error %v for a single address", nets, err)
+	}
+}
+
+func TestRateLimitBadTokens(t *testing.T) {
+	c := &config.Config{AuthSecret: "secret", AuthTokenTtl: 3600}
+	user1, _ := authService.IssueToken(c, 1, "user")
+	user2, _ := authService.IssueToken(c, 2, "user")
+	got := statuses(limitedHandler(t, c),
+		newRequest("192.0.2.1:1000", "", "junk"),
+		newRequest("192.0.2.1:1000", "", "other junk"),
+		newRequest("192.0.2.1:1000", "", "junk"),
+		// Valid tokens are limited by user, not by address.
+		newRequest("192.0.2.1:1000", "", user1),
+		newRequest("192.0.2.1:1000", "", user1),
+		newRequest("192.0.2.1:1000", "", user1),
+		newRequest("192.0.2.1:1000", "", user2))
+	if want := []int{401, 401, 429, 200, 200, 429, 200}; !equalInts(got, want) {
+		t.Errorf("got statuses %v, want %v", got, want)
+	}
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,9 +1,6 @@
 package controller
 
 import (
-	"errors"
-	"fmt"
-	"github.com/gorilla/mux"
 	"github.com/tangyang/simple-http-server/config"
 	"github.com/tangyang/simple-http-server/model"
 	"github.com/tangyang/simple-http-server/service"
@@ -11,33 +8,94 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	likeQuotaLimitHeader     = "X-Like-Quota-Limit"
+	likeQuotaRemainingHeader = "X-Like-Quota-Remaining"
 )
 
 var relationService *service.RelationService = &service.RelationService{}
 
-func getAllRelations(c *config.Config, w http.ResponseWriter, r *http.Request) interface{} {
-	vars := mux.Vars(r)
-	userId, _ := strconv.ParseInt(vars["userId"], 10, 64)
-	relations := relationService.GetRelations(c, userId)
-	return model.Result{Code: http.StatusOK, Message: "", Data: to.NewRelationToArray(relations)}
+func getAllRelations(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	filter, err := parseRelationFilter(r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	page, err := parsePage(c, r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	relations, next, err := relationService.GetRelations(c, userId, filter, page)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newPageResult(to.NewRelationToArray(relations, filter.Direction), next)
 }
 
-func addNewRelation(c *config.Config, w http.ResponseWriter, r *http.Request) interface{} {
-	m := parseParameter(r)
-	status, err := parseStatus(m["state"].(string))
+func addNewRelation(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	m, err := parseParameter(r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	state, err := getStringParameter(m, "state")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	status, err := parseStatus(state)
 	if err != nil {
-		return model.Result{Code: http.StatusBadRequest, Message: "Bad parameter status"}
+		return errorResult(r, err)
 	}
 
-	vars := mux.Vars(r)
-	userId, _ := strconv.ParseInt(vars["userId"], 10, 64)
-	otherUserId, _ := strconv.ParseInt(vars["otherUserId"], 10, 64)
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	otherUserId, err := getIdVar(r, "otherUserId")
+	if err != nil {
+		return errorResult(r, err)
+	}
 
 	relation := &model.Relation{Userid: userId, Otheruserid: otherUserId, Status: status}
 
-	relationService.AddRelation(c, relation)
+	_, quota, err := relationService.AddRelation(c, relation)
+	if quota != nil {
+		w.Header().Set(likeQuotaLimitHeader, strconv.Itoa(quota.Limit))
+		w.Header().Set(likeQuotaRemainingHeader, strconv.Itoa(quota.Remaining))
+	}
+	if err != nil {
+		if quota != nil && model.ErrorKindOf(err) == model.ErrorTooManyRequests {
+			setRetryAfter(w, quota.ResetAt.Sub(time.Now()))
+		}
+		return errorResult(r, err)
+	}
+
+	return newResult(http.StatusOK, to.NewRelationTo(relation, model.RelationOutgoing))
+}
+
+func removeRelation(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	otherUserId, err := getIdVar(r, "otherUserId")
+	if err != nil {
+		return errorResult(r, err)
+	}
 
-	return model.Result{Code: http.StatusOK, Message: "", Data: to.NewRelationTo(relation)}
+	b, err := relationService.RemoveRelation(c, userId, otherUserId)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	if !b {
+		return errorResult(r, model.NewNotFoundError("Relationship does not exist! "))
+	}
+	return newResult(http.StatusOK, nil)
 }
 
 func parseStatus(status string) (model.RelationStatus, error) {
@@ -46,6 +104,32 @@ func parseStatus(status string) (model.RelationStatus, error) {
 	} else if strings.EqualFold(status, "disliked") {
 		return model.RelationDislike, nil
 	} else {
-		return -1, errors.New(fmt.Sprintf("unrecognized status parameter, %s", status))
+		return -1, model.NewValidationError("unrecognized status parameter, %s", status)
+	}
+}
+
+// parseRelationFilter reads the optional state and direction query
+// parameters, by default every outgoing relation is listed. Incoming dislikes
+// are hidden, so asking for them is an error.
+func parseRelationFilter(r *http.Request) (model.RelationFilter, error) {
+	filter := model.RelationFilter{Direction: model.RelationOutgoing, Status: model.RelationAnyStatus}
+	query := r.URL.Query()
+	if v := query.Get("state"); v != "" {
+		status, ok := model.ParseRelationStatus(v)
+		if !ok {
+			return filter, model.NewValidationError("unrecognized state parameter, %s", v)
+		}
+		filter.Status = status
+	}
+	switch v := query.Get("direction"); {
+	case v == "" || strings.EqualFold(v, string(model.RelationOutgoing)):
+	case strings.EqualFold(v, string(model.RelationIncoming)):
+		filter.Direction = model.RelationIncoming
+	default:
+		return filter, model.NewValidationError("unrecognized direction parameter, %s", v)
+	}
+	if filter.Direction == model.RelationIncoming && filter.Status == model.RelationDislike {
+		return filter, model.NewValidationError("incoming relationships can not be filtered by state disliked")
 	}
+	return filter, nil
 }
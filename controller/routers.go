@@ -2,28 +2,108 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/logger"
+	"github.com/tangyang/simple-http-server/metrics"
 	"net/http"
+	"runtime/debug"
 )
 
-type handler func(c *config.Config, w http.ResponseWriter, r *http.Request) interface{}
+// handler serves one route, it returns the HTTP status code and the payload
+// to encode as the JSON response.
+type handler func(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{})
 
 var routes = map[string]map[string]handler{
 	"GET": {
-		"/users": getAllUsers,
+		"/users":                               getAllUsers,
+		"/users/{userId:[0-9]+}":               getUser,
 		"/users/{userId:[0-9]+}/relationships": getAllRelations,
+		"/users/{userId:[0-9]+}/candidates":    getCandidates,
+		"/users/{userId:[0-9]+}/photos":        getPhotos,
+		"/users/{userId:[0-9]+}/matches":       getMatches,
+		"/matches/{matchId:[0-9]+}/messages":   getMessages,
+		"/webhooks":                            getWebhooks,
+		"/webhooks/deliveries":                 getWebhookDeliveries,
 	},
 	"POST": {
-		"/users": addUser,
+		"/users":                             addUser,
+		"/tokens":                            login,
+		"/users/{userId:[0-9]+}/photos":      addPhoto,
+		"/matches/{matchId:[0-9]+}/messages": sendMessage,
+		"/webhooks":                          addWebhook,
+		"/webhooks/deliveries/{deliveryId:[0-9]+}/replay": replayWebhookDelivery,
+	},
+	"PATCH": {
+		"/users/{userId:[0-9]+}":         updateUser,
+		"/users/{userId:[0-9]+}/profile": updateProfile,
 	},
 	"PUT": {
 		"/users/{userId:[0-9]+}/relationships/{otherUserId:[0-9]+}": addNewRelation,
+		"/users/{userId:[0-9]+}/blocks/{otherUserId:[0-9]+}":        blockUser,
+		"/users/{userId:[0-9]+}/location":                           updateLocation,
+		"/users/{userId:[0-9]+}/photos/order":                       reorderPhotos,
+		"/matches/{matchId:[0-9]+}/read":                            markRead,
+	},
+	"DELETE": {
+		"/users/{userId:[0-9]+}":                                    deleteUser,
+		"/users/{userId:[0-9]+}/relationships/{otherUserId:[0-9]+}": removeRelation,
+		"/users/{userId:[0-9]+}/blocks/{otherUserId:[0-9]+}":        unblockUser,
+		"/users/{userId:[0-9]+}/photos/{photoId:[0-9]+}":            deletePhoto,
+		"/webhooks/{webhookId:[0-9]+}":                              deleteWebhook,
 	},
-	"DELETE": {},
 }
 
-func InitRouters(r *mux.Router, c *config.Config) {
+// streamHandler serves a long lived route, which writes the response itself
+// instead of returning a result.
+type streamHandler func(c *config.Config, w http.ResponseWriter, r *http.Request)
+
+// streams are the real-time routes. They are authenticated and rate limited
+// like routes, and also accept the token in an access_token query
+// parameter since browsers can not set headers on WebSocket and EventSource
+// requests.
+var streams = map[string]map[string]streamHandler{
+	"GET": {
+		"/ws":                           serveWebSocket,
+		"/users/{userId:[0-9]+}/events": serveEvents,
+	},
+}
+
+// eachRoute calls fn with every route of routes and streams.
+func eachRoute(fn func(method string, route string)) {
+	for method, mappings := range routes {
+		for route := range mappings {
+			fn(method, route)
+		}
+	}
+	for method, mappings := range streams {
+		for route := range mappings {
+			fn(method, route)
+		}
+	}
+}
+
+// InitRouters registers the probes and every route of routes and streams
+// with its middleware on r. It fails when the rate-limit setting is invalid.
+func InitRouters(r *mux.Router, c *config.Config) error {
+	limiters, err := newRateLimiters(c)
+	if err != nil {
+		return err
+	}
+
+	// Probes are registered apart from the routes so that no middleware
+	// applies to them.
+	r.Path("/healthz").Methods("GET").HandlerFunc(healthz)
+	r.Path("/readyz").Methods("GET").HandlerFunc(readyz(c))
+	r.Path("/metrics").Methods("GET").Handler(metrics.Handler())
+
+	// Photos are served without authentication, like from a CDN: their
+	// URLs can not be guessed and are only given to users allowed to see
+	// them.
+	photoRoute := "/photos/{key:[0-9a-f]+(?:_thumb)?\\.(?:jpg|png)}"
+	r.Path(photoRoute).Methods("GET").HandlerFunc(withRouteVars(requestId(accessLog("/photos/{key}", instrument("GET", "/photos/{key}", servePhoto(c))))))
+
 	for method, mappings := range routes {
 		for route, fct := range mappings {
 
@@ -31,13 +111,69 @@ func InitRouters(r *mux.Router, c *config.Config) {
 			localFct := fct
 
 			wrap := func(w http.ResponseWriter, r *http.Request) {
-				result := localFct(c, w, r)
-				w.Header().Set("Content-type", "application/json")
-				json.NewEncoder(w).Encode(result)
+				status, result := localFct(c, w, r)
+				writeResult(c, w, status, result)
 			}
 			localMethod := method
 
-			r.Path(localRoute).Methods(localMethod).HandlerFunc(wrap)
+			h := recoverPanic(c, wrap)
+			h = authenticate(c, localMethod, localRoute, h)
+			h = limitRate(c, limiters[localMethod+" "+localRoute], localMethod, localRoute, h)
+			h = instrument(localMethod, localRoute, h)
+			h = accessLog(localRoute, h)
+			h = requestId(h)
+			h = withRouteVars(h)
+			r.Path(localRoute).Methods(localMethod).HandlerFunc(h)
 		}
 	}
+	for method, mappings := range streams {
+		for route, fct := range mappings {
+			localRoute := route
+			localFct := fct
+			localMethod := method
+
+			h := recoverPanic(c, func(w http.ResponseWriter, r *http.Request) { localFct(c, w, r) })
+			h = authenticate(c, localMethod, localRoute, h)
+			h = limitRate(c, limiters[localMethod+" "+localRoute], localMethod, localRoute, h)
+			h = queryToken(h)
+			h = instrumentStream(localMethod, localRoute, h)
+			h = accessLog(localRoute, h)
+			h = requestId(h)
+			h = withRouteVars(h)
+			r.Path(localRoute).Methods(localMethod).HandlerFunc(h)
+		}
+	}
+	return nil
+}
+
+// writeResult encodes result as the JSON response with the given status. In
+// legacy status mode the response is always 200 and clients read the status
+// from model.Result.Code.
+func writeResult(c *config.Config, w http.ResponseWriter, status int, result interface{}) {
+	if c.LegacyStatus {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, result)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// recoverPanic turns a panic in next into a 500 response instead of letting
+// it kill the connection.
+func recoverPanic(c *config.Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.FromContext(r.Context()).Error("panic while serving request", "method", r.Method, "path", r.URL.Path,
+					"panic", fmt.Sprint(err), "stack", string(debug.Stack()))
+				status, result := errorResult(r, fmt.Errorf("panic: %v", err))
+				writeResult(c, w, status, result)
+			}
+		}()
+		next(w, r)
+	}
 }
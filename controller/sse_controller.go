@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/event"
+	"github.com/tangyang/simple-http-server/model"
+	"github.com/tangyang/simple-http-server/to"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sseRetryMs is the reconnection delay suggested to EventSource clients.
+const sseRetryMs = 3000
+
+// serveEvents streams the events of userId as Server-Sent Events. Every
+// event carries its id, a client reconnecting with the Last-Event-ID header,
+// or the last_event_id query parameter, first gets the events it missed, or
+// a resync event when some of them are not known anymore. A comment is sent
+// every c.SseHeartbeatInterval seconds to keep proxies from timing out.
+func serveEvents(c *config.Config, w http.ResponseWriter, r *http.Request) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		status, result := errorResult(r, err)
+		writeResult(c, w, status, result)
+		return
+	}
+	lastId := r.Header.Get("Last-Event-ID")
+	if lastId == "" {
+		lastId = r.URL.Query().Get("last_event_id")
+	}
+	var subscription *event.Subscription
+	var replay []model.Event
+	complete := true
+	if lastId == "" {
+		subscription, err = eventService.Subscribe(c, userId)
+	} else if after, parseErr := strconv.ParseInt(lastId, 10, 64); parseErr != nil {
+		err = model.NewValidationError("Bad Last-Event-ID. ")
+	} else {
+		subscription, replay, complete, err = eventService.Resume(c, userId, after)
+	}
+	if err != nil {
+		status, result := errorResult(r, err)
+		writeResult(c, w, status, result)
+		return
+	}
+	defer subscription.Close()
+	defer trackStream()()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	send := func(write func(io.Writer) error) bool {
+		rc.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return write(w) == nil && rc.Flush() == nil
+	}
+	ok := send(func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetryMs)
+		if err == nil && !complete {
+			err = writeSseEvent(w, &model.Event{Type: model.EventResync, UserId: userId, CreatedAt: time.Now()})
+		}
+		for i := 0; err == nil && i < len(replay); i++ {
+			err = writeSseEvent(w, &replay[i])
+		}
+		return err
+	})
+	if !ok {
+		return
+	}
+
+	heartbeat := time.NewTicker(time.Duration(c.SseHeartbeatInterval) * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e := <-subscription.Events():
+			if !send(func(w io.Writer) error { return writeSseEvent(w, &e) }) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(func(w io.Writer) error {
+				_, err := io.WriteString(w, ": heartbeat\n\n")
+				return err
+			}) {
+				return
+			}
+		case <-subscription.Dropped():
+			// The client reconnects and resumes from the replay.
+			return
+		case <-r.Context().Done():
+			return
+		case <-closingStreams:
+			return
+		}
+	}
+}
+
+// writeSseEvent writes e, an event without id leaves the last event id of
+// the client unchanged.
+func writeSseEvent(w io.Writer, e *model.Event) error {
+	b, err := json.Marshal(to.NewEventTo(e))
+	if err != nil {
+		return err
+	}
+	if e.Id != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", e.Id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, b)
+	return err
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	// closingStreams is closed when the server shuts down, the real-time
+	// connections end when they see it.
+	closingStreams   = make(chan struct{})
+	closeStreamsOnce sync.Once
+	activeStreams    sync.WaitGroup
+)
+
+// trackStream counts a real-time connection until the returned function is
+// called.
+func trackStream() func() {
+	activeStreams.Add(1)
+	return activeStreams.Done
+}
+
+// CloseStreams ends the real-time connections and waits for them until ctx
+// is done. http.Server.Shutdown does not close them itself: it ignores
+// hijacked connections and would wait for the others until its timeout.
+func CloseStreams(ctx context.Context) error {
+	closeStreamsOnce.Do(func() { close(closingStreams) })
+	done := make(chan struct{})
+	go func() {
+		activeStreams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,33 +1,242 @@
 package controller
 
 import (
-	// "fmt"
 	"github.com/tangyang/simple-http-server/config"
 	"github.com/tangyang/simple-http-server/model"
 	"github.com/tangyang/simple-http-server/service"
 	"github.com/tangyang/simple-http-server/to"
 	"net/http"
+	"sort"
+	"time"
 )
 
 var userService *service.UserService = &service.UserService{}
 
-func addUser(c *config.Config, w http.ResponseWriter, r *http.Request) interface{} {
-	m := parseParameter(r)
-	name := m["name"].(string)
-	if len(name) <= 0 {
-		return model.Result{Code: http.StatusBadRequest, Message: "Name parameter is required! "}
+func addUser(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	m, err := parseParameter(r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	name, err := getStringParameter(m, "name")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	password, err := getOptionalStringParameter(m, "password")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	user := &model.User{Name: name}
+	if err := userService.AddUser(c, user, password); err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusCreated, to.NewUserTo(user))
+}
+
+func getAllUsers(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	page, err := parsePage(c, r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	users, next, err := userService.GetUsers(c, page)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	tos := to.NewUserToArray(users)
+	list := make([]*to.UserTo, len(tos))
+	for i := range tos {
+		list[i] = &tos[i]
+	}
+	if err := withPhotos(c, list...); err != nil {
+		return errorResult(r, err)
+	}
+	return newPageResult(tos, next)
+}
+
+func getUser(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	user, err := userService.GetUser(c, userId)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	var distanceKm *float64
+	if viewerId, err := callerId(c, r); err == nil {
+		if distanceKm, err = userService.GetDistance(c, viewerId, user); err != nil {
+			return errorResult(r, err)
+		}
+	}
+	u := to.NewViewedUserTo(user, distanceKm)
+	if err := withPhotos(c, u); err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, u)
+}
+
+// userResult answers with user and its photos.
+func userResult(c *config.Config, r *http.Request, user *model.User) (int, interface{}) {
+	u := to.NewUserTo(user)
+	if err := withPhotos(c, u); err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, u)
+}
+
+func updateUser(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	m, err := parseParameter(r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	update := model.AccountUpdate{}
+	if _, ok := m["name"]; ok {
+		name, err := getStringParameter(m, "name")
+		if err != nil {
+			return errorResult(r, err)
+		}
+		update.Name = &name
 	}
-	b, err := userService.AddUser(c, &model.User{Name: name})
-	if !b {
-		return model.Result{Code: http.StatusBadRequest, Message: "Name already exists! "}
+	if _, ok := m["password"]; ok {
+		password, err := getStringParameter(m, "password")
+		if err != nil {
+			return errorResult(r, err)
+		}
+		update.Password = &password
+		if _, ok := m["old_password"]; ok {
+			if update.OldPassword, err = getStringParameter(m, "old_password"); err != nil {
+				return errorResult(r, err)
+			}
+		}
 	}
+	if _, ok := m["premium"]; ok {
+		premium, err := getBoolParameter(m, "premium")
+		if err != nil {
+			return errorResult(r, err)
+		}
+		update.Premium = &premium
+	}
+	if update.Name == nil && update.Password == nil && update.Premium == nil {
+		return errorResult(r, model.NewValidationError("name, password or premium parameter is required! "))
+	}
+	user, err := userService.UpdateAccount(c, userId, update, isAdmin(c, r))
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return userResult(c, r, user)
+}
+
+func updateProfile(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	m, err := parseParameter(r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	update, err := parseProfileUpdate(m)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	user, err := userService.UpdateProfile(c, userId, update)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return userResult(c, r, user)
+}
+
+// parseProfileUpdate reads the profile fields present in the body m, every
+// field with a value of the wrong type or unknown is reported.
+func parseProfileUpdate(m map[string]interface{}) (model.ProfileUpdate, error) {
+	update := model.ProfileUpdate{}
+	var fields []model.FieldError
+	for key, v := range m {
+		switch key {
+		case "birth_date":
+			s, ok := v.(string)
+			birthDate, err := time.Parse("2006-01-02", s)
+			if !ok || err != nil {
+				fields = append(fields, model.FieldError{Field: key, Message: "Birth date must be a date like 1990-01-31. "})
+				continue
+			}
+			update.BirthDate = &birthDate
+		case "gender", "bio":
+			s, ok := v.(string)
+			if !ok {
+				fields = append(fields, model.FieldError{Field: key, Message: key + " must be a string. "})
+				continue
+			}
+			if key == "gender" {
+				update.Gender = &s
+			} else {
+				update.Bio = &s
+			}
+		case "interested_in":
+			list, ok := v.([]interface{})
+			genders := make([]string, 0, len(list))
+			for _, item := range list {
+				if g, isString := item.(string); isString {
+					genders = append(genders, g)
+				} else {
+					ok = false
+				}
+			}
+			if !ok {
+				fields = append(fields, model.FieldError{Field: key, Message: "Interested in must be a list of genders. "})
+				continue
+			}
+			update.InterestedIn = &genders
+		default:
+			fields = append(fields, model.FieldError{Field: key, Message: "Unknown profile field. "})
+		}
+	}
+	if len(fields) > 0 {
+		sort.Sort(fieldErrorsByField(fields))
+		return update, model.NewFieldValidationError(fields)
+	}
+	return update, nil
+}
+
+type fieldErrorsByField []model.FieldError
+
+func (s fieldErrorsByField) Len() int           { return len(s) }
+func (s fieldErrorsByField) Less(i, j int) bool { return s[i].Field < s[j].Field }
+func (s fieldErrorsByField) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func updateLocation(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	m, err := parseParameter(r)
 	if err != nil {
-		return model.Result{Code: http.StatusInternalServerError, Message: "Something is wrong with server. "}
+		return errorResult(r, err)
 	}
-	return model.Result{Code: http.StatusOK, Message: "", Data: to.NewUserTo(userService.GetUserByName(c, name))}
+	latitude, err := getNumberParameter(m, "latitude")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	longitude, err := getNumberParameter(m, "longitude")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	if err := userService.SetLocation(c, userId, latitude, longitude); err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, nil)
 }
 
-func getAllUsers(c *config.Config, w http.ResponseWriter, r *http.Request) interface{} {
-	users := userService.GetAllUsers(c)
-	return model.Result{Code: http.StatusOK, Message: "", Data: to.NewUserToArray(users)}
+func deleteUser(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	userId, err := getIdVar(r, "userId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	if err := userService.DeleteUser(c, userId); err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, nil)
 }
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+	"github.com/tangyang/simple-http-server/service"
+	"github.com/tangyang/simple-http-server/to"
+	"net/http"
+)
+
+var webhookService *service.WebhookService = &service.WebhookService{}
+
+// requireAdmin fails unless the caller is an admin, webhooks are managed by
+// admins only.
+func requireAdmin(c *config.Config, r *http.Request) error {
+	if !isAdmin(c, r) {
+		return model.NewForbiddenError("Only admins can manage webhooks! ")
+	}
+	return nil
+}
+
+func addWebhook(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	if err := requireAdmin(c, r); err != nil {
+		return errorResult(r, err)
+	}
+	m, err := parseParameter(r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	url, err := getStringParameter(m, "url")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	eventTypes, err := getStringListParameter(m, "event_types")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	secret, err := getOptionalStringParameter(m, "secret")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	webhook, err := webhookService.AddWebhook(c, url, eventTypes, secret)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusCreated, to.NewWebhookTo(webhook, true))
+}
+
+func getWebhooks(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	if err := requireAdmin(c, r); err != nil {
+		return errorResult(r, err)
+	}
+	webhooks, err := webhookService.GetWebhooks(c)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, to.NewWebhookToArray(webhooks))
+}
+
+func deleteWebhook(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	if err := requireAdmin(c, r); err != nil {
+		return errorResult(r, err)
+	}
+	webhookId, err := getIdVar(r, "webhookId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	if err := webhookService.DeleteWebhook(c, webhookId); err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, nil)
+}
+
+func getWebhookDeliveries(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	if err := requireAdmin(c, r); err != nil {
+		return errorResult(r, err)
+	}
+	page, err := parsePage(c, r)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	deliveries, next, err := webhookService.GetDeliveries(c, r.URL.Query().Get("status"), page)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newPageResult(to.NewWebhookDeliveryToArray(deliveries), next)
+}
+
+func replayWebhookDelivery(c *config.Config, w http.ResponseWriter, r *http.Request) (int, interface{}) {
+	if err := requireAdmin(c, r); err != nil {
+		return errorResult(r, err)
+	}
+	deliveryId, err := getIdVar(r, "deliveryId")
+	if err != nil {
+		return errorResult(r, err)
+	}
+	delivery, err := webhookService.ReplayDelivery(c, deliveryId)
+	if err != nil {
+		return errorResult(r, err)
+	}
+	return newResult(http.StatusOK, to.NewWebhookDeliveryTo(delivery))
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/logger"
+	"github.com/tangyang/simple-http-server/metrics"
+	"github.com/tangyang/simple-http-server/model"
+	"github.com/tangyang/simple-http-server/service"
+	"github.com/tangyang/simple-http-server/to"
+	"github.com/tangyang/simple-http-server/ws"
+	"net/http"
+	"time"
+)
+
+var eventService *service.EventService = &service.EventService{}
+
+// wsWriteWait bounds the time to write a frame, a client which does not
+// read for that long is disconnected.
+const wsWriteWait = 10 * time.Second
+
+var wsDisconnectsTotal = metrics.NewCounterVec("ws_disconnects_total",
+	"Number of WebSocket connections ended, by reason.", "reason")
+
+// serveWebSocket pushes the events of the caller as JSON text messages.
+// Messages sent by the client are ignored. The connection is pinged every
+// c.WsPingInterval seconds and closed when the client stops answering, or
+// when it reads too slowly to drain its send buffer.
+func serveWebSocket(c *config.Config, w http.ResponseWriter, r *http.Request) {
+	userId, err := callerId(c, r)
+	if err != nil {
+		status, result := errorResult(r, err)
+		writeResult(c, w, status, result)
+		return
+	}
+	subscription, err := eventService.Subscribe(c, userId)
+	if err != nil {
+		status, result := errorResult(r, err)
+		writeResult(c, w, status, result)
+		return
+	}
+	defer subscription.Close()
+	conn, err := ws.Upgrade(w, r)
+	if err != nil {
+		if handshakeErr, ok := err.(*ws.HandshakeError); ok {
+			result := model.Result{Code: handshakeErr.Status, Message: handshakeErr.Message, RequestId: logger.RequestId(r.Context())}
+			writeResult(c, w, handshakeErr.Status, result)
+		} else {
+			logger.FromContext(r.Context()).Warn("fail to upgrade to websocket", "error", err.Error())
+		}
+		return
+	}
+	defer conn.Close()
+	defer trackStream()()
+	log := logger.FromContext(r.Context())
+	log.Info("websocket connected", "user_id", userId)
+
+	pingInterval := time.Duration(c.WsPingInterval) * time.Second
+	pongWait := 2 * pingInterval
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.OnPong = func([]byte) { conn.SetReadDeadline(time.Now().Add(pongWait)) }
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	reason := "client"
+	defer func() {
+		wsDisconnectsTotal.Inc(reason)
+		log.Info("websocket disconnected", "user_id", userId, "reason", reason)
+	}()
+	for {
+		select {
+		case e := <-subscription.Events():
+			b, err := json.Marshal(to.NewEventTo(&e))
+			if err != nil {
+				reason = "error"
+				conn.WriteClose(ws.CloseInternalError, "", time.Now().Add(wsWriteWait))
+				return
+			}
+			if err := conn.WriteText(b, time.Now().Add(wsWriteWait)); err != nil {
+				reason = "write_failed"
+				return
+			}
+		case <-subscription.Dropped():
+			reason = "too_slow"
+			conn.WriteClose(ws.CloseTryAgainLater, "too slow to receive events", time.Now().Add(wsWriteWait))
+			return
+		case <-ticker.C:
+			if err := conn.WritePing(nil, time.Now().Add(wsWriteWait)); err != nil {
+				reason = "write_failed"
+				return
+			}
+		case <-readDone:
+			return
+		case <-closingStreams:
+			reason = "shutdown"
+			conn.WriteClose(ws.CloseGoingAway, "server shutting down", time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
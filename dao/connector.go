@@ -2,15 +2,21 @@ package dao
 
 import (
 	// _ "github.com/go-pg/pg"
+	"errors"
 	"github.com/tangyang/simple-http-server/config"
 	pg "gopkg.in/pg.v4"
+	"reflect"
 	"sync"
 	"time"
+	"unsafe"
 )
 
 var connector *PostgreConnector
 var lock *sync.Mutex = &sync.Mutex{}
 
+// closed is set by Close, the pool is never opened again afterwards.
+var closed bool
+
 type PostgreConnector struct {
 	Address  string
 	DbName   string
@@ -25,6 +31,10 @@ func NewPostgreConnector(conf *config.Config) *PostgreConnector {
 	if connector == nil {
 		connector = &PostgreConnector{Address: conf.PgAddress, DbName: conf.PgDatabaseName, User: conf.PgUsername, Password: conf.PgPassword}
 		connector.Connect(conf)
+		if closed {
+			// Its calls fail like the ones of the closed pool.
+			connector.DB.Close()
+		}
 	}
 	return connector
 }
@@ -37,3 +47,70 @@ func (c *PostgreConnector) Connect(conf *config.Config) {
 		Database: c.DbName, ReadTimeout: readTimeout, WriteTimeout: writeTimeout,
 		PoolSize: conf.PgPoolsize, IdleTimeout: idleTImeout})
 }
+
+// Close closes the connection pool if a connector was created. The database
+// calls made afterwards fail instead of opening a new pool.
+func Close() error {
+	lock.Lock()
+	defer lock.Unlock()
+	if closed {
+		return errors.New("database connector is already closed")
+	}
+	closed = true
+	if connector == nil {
+		return nil
+	}
+	return connector.DB.Close()
+}
+
+// Ping runs a trivial query to check that the database is reachable.
+func Ping(conf *config.Config) error {
+	c := NewPostgreConnector(conf)
+	var one int
+	_, err := c.DB.QueryOne(pg.Scan(&one), `SELECT 1`)
+	return wrapError(err, "Fail to ping database")
+}
+
+// PoolStats are the stats the driver keeps about its connection pool: Size
+// is the most connections it opens, TotalConns the ones open and FreeConns
+// the open ones waiting for a query. Since the start, Requests counts the
+// connections asked for, Hits the ones found free and Timeouts the requests
+// which waited for a connection in vain.
+type PoolStats struct {
+	Size       int
+	Requests   uint32
+	Hits       uint32
+	Timeouts   uint32
+	TotalConns uint32
+	FreeConns  uint32
+}
+
+var errPoolStats = errors.New("the database driver does not have the expected connection pool, its stats are not available")
+
+// GetPoolStats returns the stats of the database connection pool without a
+// database call. The vendored pg.v4 keeps them in its pool but does not
+// export it, so the pool is read by reflection; TestGetPoolStats fails when
+// an update of the driver changes it.
+func GetPoolStats(conf *config.Config) (*PoolStats, error) {
+	c := NewPostgreConnector(conf)
+	field := reflect.ValueOf(c.DB).Elem().FieldByName("pool")
+	if field.Kind() != reflect.Ptr || field.IsNil() {
+		return nil, errPoolStats
+	}
+	pool := reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+	method := pool.MethodByName("Stats")
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 || method.Type().Out(0).Kind() != reflect.Ptr {
+		return nil, errPoolStats
+	}
+	out := method.Call(nil)[0].Elem()
+	stats := &PoolStats{Size: conf.PgPoolsize}
+	for name, value := range map[string]*uint32{"Requests": &stats.Requests, "Hits": &stats.Hits, "Timeouts": &stats.Timeouts,
+		"TotalConns": &stats.TotalConns, "FreeConns": &stats.FreeConns} {
+		f := out.FieldByName(name)
+		if f.Kind() != reflect.Uint32 {
+			return nil, errPoolStats
+		}
+		*value = uint32(f.Uint())
+	}
+	return stats, nil
+}
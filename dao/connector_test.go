@@ -0,0 +1,37 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+
+	"testing"
+)
+
+// TestGetPoolStats checks that the stats are still read from the pool of the
+// vendored driver, on a database which refuses connections.
+func TestGetPoolStats(t *testing.T) {
+	conf := &config.Config{PgAddress: "127.0.0.1:1", PgPoolsize: 3}
+	t.Cleanup(func() {
+		lock.Lock()
+		defer lock.Unlock()
+		connector.DB.Close()
+		connector = nil
+	})
+
+	stats, err := GetPoolStats(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (PoolStats{Size: 3}) {
+		t.Errorf("got stats %+v of an unused pool", *stats)
+	}
+	if err := Ping(conf); err == nil {
+		t.Fatal("ping of a closed port succeeded")
+	}
+	stats, err = GetPoolStats(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (PoolStats{Size: 3, Requests: 1}) {
+		t.Errorf("got stats %+v after a failed connection, want 1 request", *stats)
+	}
+}
@@ -0,0 +1,124 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+	pg "gopkg.in/pg.v4"
+
+	"time"
+)
+
+type ConversationDao struct {
+}
+
+func (d *ConversationDao) GetConversation(conf *config.Config, id int64) (*model.Conversation, error) {
+	defer observeQuery(conf, "ConversationDao.GetConversation", time.Now())
+	c := NewPostgreConnector(conf)
+	conversation := &model.Conversation{}
+	err := c.DB.Model(conversation).Where("id = ?", id).Select()
+	if err == pg.ErrNoRows {
+		return nil, model.NewNotFoundError("Match %d does not exist", id)
+	}
+	if err != nil {
+		return nil, wrapError(err, "Fail to get conversation %d", id)
+	}
+	return conversation, nil
+}
+
+func (d *ConversationDao) GetOpenConversations(conf *config.Config, userId int64, page model.Page) ([]model.Conversation, error) {
+	defer observeQuery(conf, "ConversationDao.GetOpenConversations", time.Now())
+	c := NewPostgreConnector(conf)
+	conversations := []model.Conversation{}
+	err := c.DB.Model(&conversations).Where("(userid = ? OR otheruserid = ?) AND closed_at IS NULL", userId, userId).
+		Where("id > ?", page.AfterId).Order("id").Limit(page.Limit).Select()
+	if err != nil {
+		return nil, wrapError(err, "Fail to get conversations of user %d", userId)
+	}
+	return conversations, nil
+}
+
+type unreadCount struct {
+	Conversationid int64
+	Unread         int
+}
+
+func (d *ConversationDao) CountUnread(conf *config.Config, userId int64, conversationIds []int64) (map[int64]int, error) {
+	defer observeQuery(conf, "ConversationDao.CountUnread", time.Now())
+	result := make(map[int64]int)
+	if len(conversationIds) == 0 {
+		return result, nil
+	}
+	c := NewPostgreConnector(conf)
+	var counts []unreadCount
+	_, err := c.DB.Query(&counts, `SELECT m.conversationid, count(*) AS unread FROM messages m JOIN conversations c ON c.id = m.conversationid
+		WHERE c.id IN (?) AND m.senderid <> ?
+		AND m.id > CASE WHEN c.userid = ? THEN c.user_last_read_id ELSE c.other_user_last_read_id END
+		GROUP BY m.conversationid`, pg.Ints(conversationIds), userId, userId)
+	if err != nil {
+		return nil, wrapError(err, "Fail to count unread messages of user %d", userId)
+	}
+	for _, count := range counts {
+		result[count.Conversationid] = count.Unread
+	}
+	return result, nil
+}
+
+// AddMessage locks the conversation row while inserting, so a message can
+// not slip into a conversation being closed.
+func (d *ConversationDao) AddMessage(conf *config.Config, message *model.Message, events []model.Event) (bool, error) {
+	defer observeQuery(conf, "ConversationDao.AddMessage", time.Now())
+	c := NewPostgreConnector(conf)
+	added := false
+	err := c.DB.RunInTransaction(func(tx *pg.Tx) error {
+		added = false
+		var open bool
+		_, err := tx.QueryOne(pg.Scan(&open), `SELECT closed_at IS NULL FROM conversations WHERE id = ? FOR UPDATE`, message.Conversationid)
+		if err == pg.ErrNoRows {
+			return model.NewNotFoundError("Match %d does not exist", message.Conversationid)
+		}
+		if err != nil || !open {
+			return err
+		}
+		if _, err := tx.Model(message).Create(); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE conversations SET last_message_at = ? WHERE id = ?`, message.CreatedAt, message.Conversationid); err != nil {
+			return err
+		}
+		if err := notifyEvents(tx, events); err != nil {
+			return err
+		}
+		added = true
+		return nil
+	})
+	return added, wrapError(err, "Fail to add message to conversation %d", message.Conversationid)
+}
+
+func (d *ConversationDao) GetMessages(conf *config.Config, conversationId int64, page model.Page) ([]model.Message, error) {
+	defer observeQuery(conf, "ConversationDao.GetMessages", time.Now())
+	c := NewPostgreConnector(conf)
+	messages := []model.Message{}
+	q := c.DB.Model(&messages).Where("conversationid = ?", conversationId)
+	if page.AfterId > 0 {
+		q = q.Where("id < ?", page.AfterId)
+	}
+	err := q.Order("id DESC").Limit(page.Limit).Select()
+	if err != nil {
+		return nil, wrapError(err, "Fail to get messages of conversation %d", conversationId)
+	}
+	return messages, nil
+}
+
+func (d *ConversationDao) MarkRead(conf *config.Config, conversationId int64, userId int64, messageId int64) (bool, error) {
+	defer observeQuery(conf, "ConversationDao.MarkRead", time.Now())
+	c := NewPostgreConnector(conf)
+	res, err := c.DB.Exec(`UPDATE conversations SET
+		user_last_read_id = CASE WHEN userid = ? THEN GREATEST(user_last_read_id, ?) ELSE user_last_read_id END,
+		other_user_last_read_id = CASE WHEN otheruserid = ? THEN GREATEST(other_user_last_read_id, ?) ELSE other_user_last_read_id END
+		WHERE id = ? AND EXISTS (SELECT 1 FROM messages WHERE id = ? AND conversationid = ?)`,
+		userId, messageId, userId, messageId, conversationId, messageId, conversationId)
+	if err != nil {
+		return false, wrapError(err, "Fail to mark messages of conversation %d read", conversationId)
+	}
+	return res.Affected() > 0, nil
+}
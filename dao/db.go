@@ -0,0 +1,31 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	pg "gopkg.in/pg.v4"
+	"gopkg.in/pg.v4/orm"
+	"gopkg.in/pg.v4/types"
+)
+
+// dber is implemented by both *pg.DB and *pg.Tx so that DAO methods can run
+// either on the pool or inside a transaction.
+type dber interface {
+	Model(model interface{}) *orm.Query
+	Exec(query interface{}, params ...interface{}) (*types.Result, error)
+	ExecOne(query interface{}, params ...interface{}) (*types.Result, error)
+	Query(model, query interface{}, params ...interface{}) (*types.Result, error)
+	QueryOne(model, query interface{}, params ...interface{}) (*types.Result, error)
+}
+
+var (
+	_ dber = (*pg.DB)(nil)
+	_ dber = (*pg.Tx)(nil)
+)
+
+// getDB returns tx when it is set, or the shared connection pool otherwise.
+func getDB(conf *config.Config, tx *pg.Tx) dber {
+	if tx != nil {
+		return tx
+	}
+	return NewPostgreConnector(conf).DB
+}
@@ -0,0 +1,47 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/model"
+	pg "gopkg.in/pg.v4"
+
+	"io"
+	"net"
+)
+
+// wrapError converts an error returned by pg into a *model.Error, the message
+// describes the failed operation.
+func wrapError(err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*model.Error); ok {
+		return err
+	}
+	return model.NewError(errorKind(err), err, format, args...)
+}
+
+func errorKind(err error) model.ErrorKind {
+	if err == pg.ErrNoRows {
+		return model.ErrorNotFound
+	}
+	if pgErr, ok := err.(pg.Error); ok {
+		// 23503 foreign_key_violation: the referenced user does not exist.
+		if pgErr.Field('C') == "23503" {
+			return model.ErrorNotFound
+		}
+		if pgErr.IntegrityViolation() {
+			return model.ErrorConflict
+		}
+		return model.ErrorInternal
+	}
+	if _, ok := err.(net.Error); ok || err == io.EOF {
+		return model.ErrorStorageUnavailable
+	}
+	// The pool errors live in an internal package of pg and can only be
+	// recognized by their message.
+	switch err.Error() {
+	case "pg: connection pool timeout", "pg: database is closed":
+		return model.ErrorStorageUnavailable
+	}
+	return model.ErrorInternal
+}
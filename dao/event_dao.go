@@ -0,0 +1,209 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+	pg "gopkg.in/pg.v4"
+
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// eventChannel is the channel the events are notified on.
+const eventChannel = "user_events"
+
+// maxNotifyPayload stays below the 8000 bytes limit of NOTIFY payloads.
+const maxNotifyPayload = 7900
+
+const (
+	minListenRetry = time.Second
+	maxListenRetry = 30 * time.Second
+)
+
+// Every instance notifies eventHeartbeatChannel every listenHeartbeat through
+// the pool. A listener which receives nothing for listenTimeout lost its
+// connection without being told, e.g. a half-open connection after a network
+// partition, and listens again.
+const (
+	eventHeartbeatChannel = "user_events_heartbeat"
+	listenHeartbeat       = 10 * time.Second
+	listenTimeout         = 3 * listenHeartbeat
+)
+
+// EventDao carries the events between the server instances with NOTIFY and
+// LISTEN. The listener holds one connection of the pool.
+type EventDao struct {
+	mu       sync.Mutex
+	listener *pg.Listener
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// notifyEvents notifies events on db, inside a transaction they reach the
+// listeners when it commits.
+func notifyEvents(db dber, events []model.Event) error {
+	for i := range events {
+		payload, err := eventPayload(&events[i])
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec(`SELECT pg_notify(?, ?)`, eventChannel, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventPayload encodes e for NOTIFY. When a message does not fit, only its
+// id is sent and the listeners load it.
+func eventPayload(e *model.Event) (string, error) {
+	b, err := json.Marshal(e)
+	if err != nil || len(b) <= maxNotifyPayload || e.Message == nil {
+		return string(b), err
+	}
+	stripped := *e
+	stripped.Message = &model.Message{Id: e.Message.Id}
+	b, err = json.Marshal(&stripped)
+	return string(b), err
+}
+
+// Listen listens in the background. A lost connection is opened again after
+// a growing delay, sink is then interrupted since the events notified in
+// between are lost.
+func (d *EventDao) Listen(conf *config.Config, sink EventSink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stop != nil {
+		return
+	}
+	d.stop, d.done = make(chan struct{}), make(chan struct{})
+	go d.run(conf, sink)
+}
+
+func (d *EventDao) run(conf *config.Config, sink EventSink) {
+	defer close(d.done)
+	retry, lost := time.Duration(0), false
+	for {
+		if retry > 0 {
+			select {
+			case <-d.stop:
+				return
+			case <-time.After(retry):
+			}
+		}
+		listener, err := NewPostgreConnector(conf).DB.Listen(eventChannel, eventHeartbeatChannel)
+		if err == nil && !d.setListener(listener) {
+			listener.Close()
+			return
+		}
+		if err == nil {
+			if lost {
+				listenerReconnectsTotal.Inc()
+				sink.Interrupt()
+			}
+			slog.Info("listening to events", "channel", eventChannel)
+			retry = 0
+			err = d.receive(conf, listener, sink)
+			d.setListener(nil)
+			listener.Close()
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+		}
+		lost = true
+		retry = min(max(2*retry, minListenRetry), maxListenRetry)
+		slog.Error("fail to listen to events", "channel", eventChannel, "retry_in", retry.String(), "error", err.Error())
+	}
+}
+
+// setListener records the listener in use, Close closes it. It returns false
+// once Close was called.
+func (d *EventDao) setListener(listener *pg.Listener) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.stop:
+		return false
+	default:
+	}
+	d.listener = listener
+	return true
+}
+
+// receive delivers the notifications until the connection fails or nothing,
+// not even a heartbeat, comes for listenTimeout.
+func (d *EventDao) receive(conf *config.Config, listener *pg.Listener, sink EventSink) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go sendHeartbeats(conf, stop)
+	for {
+		channel, payload, err := listener.ReceiveTimeout(listenTimeout)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return fmt.Errorf("no notification nor heartbeat for %s, the connection is lost: %v", listenTimeout, err)
+		}
+		if err != nil {
+			return err
+		}
+		if channel == eventHeartbeatChannel {
+			continue
+		}
+		var e model.Event
+		if err := json.Unmarshal([]byte(payload), &e); err != nil {
+			slog.Warn("bad event notification", "payload", payload, "error", err.Error())
+			continue
+		}
+		// Bodies are never empty, an empty one was left out by eventPayload.
+		if e.Message != nil && e.Message.Body == "" {
+			err := NewPostgreConnector(conf).DB.Model(e.Message).Where("id = ?", e.Message.Id).Select()
+			if err != nil {
+				slog.Error("fail to load notified message", "message_id", e.Message.Id, "error", err.Error())
+				sink.Interrupt()
+				continue
+			}
+		}
+		sink.Publish(e)
+	}
+}
+
+// sendHeartbeats notifies eventHeartbeatChannel every listenHeartbeat until
+// stop is closed.
+func sendHeartbeats(conf *config.Config, stop chan struct{}) {
+	ticker := time.NewTicker(listenHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := NewPostgreConnector(conf).DB.Exec(`SELECT pg_notify(?, '')`, eventHeartbeatChannel); err != nil {
+				slog.Warn("fail to send event listener heartbeat", "error", err.Error())
+			}
+		}
+	}
+}
+
+// Close stops listening and waits for the listener to be closed.
+func (d *EventDao) Close() error {
+	d.mu.Lock()
+	if d.stop == nil {
+		d.mu.Unlock()
+		return nil
+	}
+	select {
+	case <-d.stop:
+	default:
+		close(d.stop)
+		if d.listener != nil {
+			d.listener.Close()
+		}
+	}
+	d.mu.Unlock()
+	<-d.done
+	return nil
+}
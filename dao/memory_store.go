@@ -0,0 +1,882 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps users, relations and photos in process memory. It is meant
+// for local development, demos and tests; nothing survives a restart.
+type MemoryStorage struct {
+	mu            sync.RWMutex
+	users         map[int64]*model.User
+	userNames     map[string]int64
+	relations     map[int64]*model.Relation
+	relationPairs map[[2]int64]int64
+	dailyLikes    map[dailyLikeKey]int
+	blocks        map[[2]int64]bool
+	photos        map[int64]*model.Photo
+	conversations map[int64]*model.Conversation
+	// messages holds the messages of each conversation by increasing id.
+	messages           map[int64][]model.Message
+	webhooks           map[int64]*model.Webhook
+	deliveries         map[int64]*model.WebhookDelivery
+	nextUserId         int64
+	nextRelationId     int64
+	nextPhotoId        int64
+	nextConversationId int64
+	nextMessageId      int64
+	nextWebhookId      int64
+	nextDeliveryId     int64
+	// sink receives the notified events, it is set by MemoryEventDao.
+	sink EventSink
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:         make(map[int64]*model.User),
+		userNames:     make(map[string]int64),
+		relations:     make(map[int64]*model.Relation),
+		relationPairs: make(map[[2]int64]int64),
+		dailyLikes:    make(map[dailyLikeKey]int),
+		blocks:        make(map[[2]int64]bool),
+		photos:        make(map[int64]*model.Photo),
+		conversations: make(map[int64]*model.Conversation),
+		messages:      make(map[int64][]model.Message),
+		webhooks:      make(map[int64]*model.Webhook),
+		deliveries:    make(map[int64]*model.WebhookDelivery),
+	}
+}
+
+type dailyLikeKey struct {
+	userId int64
+	day    string
+}
+
+type MemoryUserDao struct {
+	m *MemoryStorage
+}
+
+// AddUser mirrors the SelectOrCreate semantic of UserDao: when the name is
+// taken, user is filled with the stored row and false is returned.
+func (u *MemoryUserDao) AddUser(conf *config.Config, user *model.User) (bool, error) {
+	u.m.mu.Lock()
+	defer u.m.mu.Unlock()
+	if id, ok := u.m.userNames[user.Name]; ok {
+		*user = *u.m.users[id]
+		return false, nil
+	}
+	u.m.nextUserId++
+	user.Id = u.m.nextUserId
+	stored := *user
+	u.m.users[stored.Id] = &stored
+	u.m.userNames[stored.Name] = stored.Id
+	return true, u.m.queueWebhookEvent(model.WebhookUserCreated, userWebhookData(user), time.Now())
+}
+
+func (u *MemoryUserDao) GetUserByName(conf *config.Config, name string) (*model.User, error) {
+	u.m.mu.RLock()
+	defer u.m.mu.RUnlock()
+	id, ok := u.m.userNames[name]
+	if !ok {
+		return nil, model.NewNotFoundError("User %s does not exist", name)
+	}
+	user := *u.m.users[id]
+	return &user, nil
+}
+
+func (u *MemoryUserDao) GetUserById(conf *config.Config, id int64) (*model.User, error) {
+	u.m.mu.RLock()
+	defer u.m.mu.RUnlock()
+	stored, ok := u.m.users[id]
+	if !ok {
+		return nil, model.NewNotFoundError("User %d does not exist", id)
+	}
+	user := *stored
+	return &user, nil
+}
+
+func (u *MemoryUserDao) UpdateUser(conf *config.Config, user *model.User, passwordHash string) error {
+	u.m.mu.Lock()
+	defer u.m.mu.Unlock()
+	stored, ok := u.m.users[user.Id]
+	if !ok {
+		return model.NewNotFoundError("User %d does not exist", user.Id)
+	}
+	if id, ok := u.m.userNames[user.Name]; ok && id != user.Id {
+		return model.NewConflictError("Name already exists! ")
+	}
+	if stored.PasswordHash != passwordHash {
+		return model.NewConflictError("Password of user %d changed meanwhile, try again! ", user.Id)
+	}
+	delete(u.m.userNames, stored.Name)
+	stored.Name, stored.PasswordHash, stored.Premium = user.Name, user.PasswordHash, user.Premium
+	u.m.userNames[stored.Name] = stored.Id
+	return nil
+}
+
+func (u *MemoryUserDao) UpdateUserProfile(conf *config.Config, user *model.User) error {
+	u.m.mu.Lock()
+	defer u.m.mu.Unlock()
+	stored, ok := u.m.users[user.Id]
+	if !ok {
+		return model.NewNotFoundError("User %d does not exist", user.Id)
+	}
+	stored.BirthDate = user.BirthDate
+	stored.Gender = user.Gender
+	stored.InterestedIn = append([]string(nil), user.InterestedIn...)
+	stored.Bio = user.Bio
+	stored.LastActive = user.LastActive
+	return nil
+}
+
+func (u *MemoryUserDao) TouchUser(conf *config.Config, id int64, lastActive time.Time) error {
+	u.m.mu.Lock()
+	defer u.m.mu.Unlock()
+	if stored, ok := u.m.users[id]; ok {
+		stored.LastActive = lastActive
+	}
+	return nil
+}
+
+func (u *MemoryUserDao) SetUserLocation(conf *config.Config, id int64, latitude float64, longitude float64, now time.Time, notBefore time.Time) (bool, error) {
+	u.m.mu.Lock()
+	defer u.m.mu.Unlock()
+	stored, ok := u.m.users[id]
+	if !ok {
+		return false, model.NewNotFoundError("User %d does not exist", id)
+	}
+	if stored.LocatedAt.After(notBefore) {
+		return false, nil
+	}
+	stored.Latitude, stored.Longitude, stored.LocatedAt = &latitude, &longitude, now
+	return true, nil
+}
+
+func (u *MemoryUserDao) DeleteUser(conf *config.Config, id int64) (bool, error) {
+	u.m.mu.Lock()
+	defer u.m.mu.Unlock()
+	stored, ok := u.m.users[id]
+	if !ok {
+		return false, nil
+	}
+	for relationId, relation := range u.m.relations {
+		if relation.Userid == id || relation.Otheruserid == id {
+			delete(u.m.relationPairs, [2]int64{relation.Userid, relation.Otheruserid})
+			delete(u.m.relations, relationId)
+		}
+	}
+	for photoId, photo := range u.m.photos {
+		if photo.Userid == id {
+			delete(u.m.photos, photoId)
+		}
+	}
+	for conversationId, conversation := range u.m.conversations {
+		if conversation.HasMember(id) {
+			delete(u.m.messages, conversationId)
+			delete(u.m.conversations, conversationId)
+		}
+	}
+	for key := range u.m.blocks {
+		if key[0] == id || key[1] == id {
+			delete(u.m.blocks, key)
+		}
+	}
+	for key := range u.m.dailyLikes {
+		if key.userId == id {
+			delete(u.m.dailyLikes, key)
+		}
+	}
+	delete(u.m.userNames, stored.Name)
+	delete(u.m.users, id)
+	return true, nil
+}
+
+func (u *MemoryUserDao) GetUsers(conf *config.Config, page model.Page) ([]model.User, error) {
+	u.m.mu.RLock()
+	defer u.m.mu.RUnlock()
+	users := []model.User{}
+	for _, user := range u.m.users {
+		if user.Id > page.AfterId {
+			users = append(users, *user)
+		}
+	}
+	sort.Sort(usersById(users))
+	if len(users) > page.Limit {
+		users = users[:page.Limit]
+	}
+	return users, nil
+}
+
+func (u *MemoryUserDao) GetCandidates(conf *config.Config, userId int64, likers bool, near *model.Circle, page model.Page) ([]model.User, error) {
+	u.m.mu.RLock()
+	defer u.m.mu.RUnlock()
+	users := []model.User{}
+	for _, user := range u.m.users {
+		if user.Id <= page.AfterId || user.Id == userId {
+			continue
+		}
+		if _, swiped := u.m.relationPairs[[2]int64{userId, user.Id}]; swiped {
+			continue
+		}
+		if u.m.blocks[[2]int64{userId, user.Id}] || u.m.blocks[[2]int64{user.Id, userId}] {
+			continue
+		}
+		if near != nil && (!user.HasLocation() || !near.Contains(*user.Latitude, *user.Longitude)) {
+			continue
+		}
+		likes := false
+		if id, ok := u.m.relationPairs[[2]int64{user.Id, userId}]; ok {
+			likes = u.m.relations[id].Status == model.RelationLike
+		}
+		if likes == likers {
+			users = append(users, *user)
+		}
+	}
+	sort.Sort(usersById(users))
+	if len(users) > page.Limit {
+		users = users[:page.Limit]
+	}
+	return users, nil
+}
+
+type MemoryRelationDao struct {
+	m *MemoryStorage
+	// inTx is set on the store handed to RunInTransaction, which already
+	// holds the write lock.
+	inTx bool
+	// notified holds the events to deliver once the transaction is over.
+	notified []model.Event
+}
+
+func (r *MemoryRelationDao) lock() func() {
+	if r.inTx {
+		return func() {}
+	}
+	r.m.mu.Lock()
+	return r.m.mu.Unlock
+}
+
+func (r *MemoryRelationDao) rlock() func() {
+	if r.inTx {
+		return func() {}
+	}
+	r.m.mu.RLock()
+	return r.m.mu.RUnlock
+}
+
+// RunInTransaction holds the storage write lock while fn runs, which makes
+// fn atomic with respect to every other store call. When fn fails, the data
+// the relation store writes is restored as it was before fn, ids are not
+// reused like the ones of sequences.
+func (r *MemoryRelationDao) RunInTransaction(conf *config.Config, fn func(RelationStore) error) error {
+	if r.inTx {
+		return fn(r)
+	}
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	saved := r.m.saveRelations()
+	tx := &MemoryRelationDao{m: r.m, inTx: true}
+	if err := fn(tx); err != nil {
+		r.m.restoreRelations(saved)
+		return err
+	}
+	r.m.deliver(tx.notified)
+	return nil
+}
+
+// relationState is a copy of the data written by the relation store.
+type relationState struct {
+	relations     map[int64]*model.Relation
+	relationPairs map[[2]int64]int64
+	dailyLikes    map[dailyLikeKey]int
+	blocks        map[[2]int64]bool
+	conversations map[int64]*model.Conversation
+	deliveries    map[int64]*model.WebhookDelivery
+}
+
+func (m *MemoryStorage) saveRelations() *relationState {
+	return &relationState{
+		relations:     clonePointers(m.relations),
+		relationPairs: maps.Clone(m.relationPairs),
+		dailyLikes:    maps.Clone(m.dailyLikes),
+		blocks:        maps.Clone(m.blocks),
+		conversations: clonePointers(m.conversations),
+		deliveries:    clonePointers(m.deliveries),
+	}
+}
+
+func (m *MemoryStorage) restoreRelations(s *relationState) {
+	m.relations, m.relationPairs, m.dailyLikes = s.relations, s.relationPairs, s.dailyLikes
+	m.blocks, m.conversations, m.deliveries = s.blocks, s.conversations, s.deliveries
+}
+
+// clonePointers copies src and the values it points to.
+func clonePointers[K comparable, V any](src map[K]*V) map[K]*V {
+	dst := make(map[K]*V, len(src))
+	for k, v := range src {
+		copied := *v
+		dst[k] = &copied
+	}
+	return dst
+}
+
+func (r *MemoryRelationDao) NotifyEvents(conf *config.Config, events []model.Event) error {
+	if r.inTx {
+		r.notified = append(r.notified, events...)
+		return nil
+	}
+	defer r.lock()()
+	r.m.deliver(events)
+	return nil
+}
+
+func (r *MemoryRelationDao) LockUserPair(conf *config.Config, userId int64, otherUserId int64) error {
+	return nil
+}
+
+func (r *MemoryRelationDao) AddOrUpdateRelation(conf *config.Config, relation *model.Relation) (bool, error) {
+	defer r.lock()()
+	// Same guarantee as the foreign keys of the relations table.
+	for _, userId := range []int64{relation.Userid, relation.Otheruserid} {
+		if _, ok := r.m.users[userId]; !ok {
+			return false, model.NewNotFoundError("User %d does not exist", userId)
+		}
+	}
+	key := [2]int64{relation.Userid, relation.Otheruserid}
+	id, ok := r.m.relationPairs[key]
+	if ok {
+		relation.Id = id
+		r.m.relations[id].Status = relation.Status
+	} else {
+		r.m.nextRelationId++
+		relation.Id = r.m.nextRelationId
+		stored := *relation
+		r.m.relations[stored.Id] = &stored
+		r.m.relationPairs[key] = stored.Id
+	}
+	return !ok, r.m.queueWebhookEvent(model.WebhookSwipe, swipeWebhookData(relation), time.Now())
+}
+
+func (r *MemoryRelationDao) GetRelationByUserIdPairs(conf *config.Config, userId int64, otherUserId int64) (*model.Relation, error) {
+	defer r.rlock()()
+	id, ok := r.m.relationPairs[[2]int64{userId, otherUserId}]
+	if !ok {
+		return nil, model.NewNotFoundError("Relation from user %d to user %d does not exist", userId, otherUserId)
+	}
+	relation := *r.m.relations[id]
+	return &relation, nil
+}
+
+func (r *MemoryRelationDao) UpdateRelation(conf *config.Config, relation *model.Relation) error {
+	defer r.lock()()
+	if stored, ok := r.m.relations[relation.Id]; ok {
+		stored.Status = relation.Status
+	}
+	return nil
+}
+
+func (r *MemoryRelationDao) DeleteRelation(conf *config.Config, userId int64, otherUserId int64) (bool, error) {
+	defer r.lock()()
+	key := [2]int64{userId, otherUserId}
+	id, ok := r.m.relationPairs[key]
+	if !ok {
+		return false, nil
+	}
+	delete(r.m.relationPairs, key)
+	delete(r.m.relations, id)
+	return true, nil
+}
+
+func (r *MemoryRelationDao) GetRelationsByUserId(conf *config.Config, userId int64, filter model.RelationFilter, page model.Page) ([]model.Relation, error) {
+	defer r.rlock()()
+	relations := []model.Relation{}
+	for _, relation := range r.m.relations {
+		owner := relation.Userid
+		if filter.Direction == model.RelationIncoming {
+			owner = relation.Otheruserid
+		}
+		if owner != userId || relation.Id <= page.AfterId {
+			continue
+		}
+		if filter.Direction == model.RelationIncoming && relation.Status == model.RelationDislike {
+			continue
+		}
+		if filter.Status != model.RelationAnyStatus && relation.Status != filter.Status {
+			continue
+		}
+		relations = append(relations, *relation)
+	}
+	sort.Sort(relationsById(relations))
+	if len(relations) > page.Limit {
+		relations = relations[:page.Limit]
+	}
+	return relations, nil
+}
+
+func (r *MemoryRelationDao) GetDailyLikes(conf *config.Config, userId int64, day time.Time) (int, error) {
+	defer r.rlock()()
+	return r.m.dailyLikes[dailyLikeKey{userId, day.Format(dayLayout)}], nil
+}
+
+func (r *MemoryRelationDao) AddDailyLike(conf *config.Config, userId int64, day time.Time, limit int) (int, bool, error) {
+	defer r.lock()()
+	key := dailyLikeKey{userId, day.Format(dayLayout)}
+	if r.m.dailyLikes[key] >= limit {
+		return r.m.dailyLikes[key], false, nil
+	}
+	r.m.dailyLikes[key]++
+	return r.m.dailyLikes[key], true, nil
+}
+
+func (r *MemoryRelationDao) AddBlock(conf *config.Config, userId int64, blockedUserId int64) (bool, error) {
+	defer r.lock()()
+	for _, id := range []int64{userId, blockedUserId} {
+		if _, ok := r.m.users[id]; !ok {
+			return false, model.NewNotFoundError("User %d does not exist", id)
+		}
+	}
+	key := [2]int64{userId, blockedUserId}
+	if r.m.blocks[key] {
+		return false, nil
+	}
+	r.m.blocks[key] = true
+	return true, nil
+}
+
+func (r *MemoryRelationDao) DeleteBlock(conf *config.Config, userId int64, blockedUserId int64) (bool, error) {
+	defer r.lock()()
+	key := [2]int64{userId, blockedUserId}
+	if !r.m.blocks[key] {
+		return false, nil
+	}
+	delete(r.m.blocks, key)
+	return true, nil
+}
+
+type usersById []model.User
+
+func (s usersById) Len() int           { return len(s) }
+func (s usersById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s usersById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type relationsById []model.Relation
+
+func (s relationsById) Len() int           { return len(s) }
+func (s relationsById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s relationsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (r *MemoryRelationDao) OpenConversation(conf *config.Config, userId int64, otherUserId int64, now time.Time) (int64, error) {
+	defer r.lock()()
+	userId, otherUserId = model.ConversationPair(userId, otherUserId)
+	if conversation := r.m.openConversation(userId, otherUserId); conversation != nil {
+		return conversation.Id, nil
+	}
+	r.m.nextConversationId++
+	id := r.m.nextConversationId
+	r.m.conversations[id] = &model.Conversation{Id: id, Userid: userId, Otheruserid: otherUserId, CreatedAt: now}
+	return id, r.m.queueWebhookEvent(model.WebhookMatchCreated, matchWebhookData(id, userId, otherUserId), now)
+}
+
+func (r *MemoryRelationDao) CloseConversation(conf *config.Config, userId int64, otherUserId int64, now time.Time) (int64, error) {
+	defer r.lock()()
+	conversation := r.m.openConversation(model.ConversationPair(userId, otherUserId))
+	if conversation == nil {
+		return 0, nil
+	}
+	conversation.ClosedAt = now
+	return conversation.Id, r.m.queueWebhookEvent(model.WebhookMatchDeleted,
+		matchWebhookData(conversation.Id, conversation.Userid, conversation.Otheruserid), now)
+}
+
+// openConversation returns the open conversation between the ordered pair of
+// users, or nil. The caller holds the lock.
+func (m *MemoryStorage) openConversation(userId int64, otherUserId int64) *model.Conversation {
+	for _, conversation := range m.conversations {
+		if conversation.Userid == userId && conversation.Otheruserid == otherUserId && conversation.IsOpen() {
+			return conversation
+		}
+	}
+	return nil
+}
+
+type MemoryPhotoDao struct {
+	m *MemoryStorage
+}
+
+func (p *MemoryPhotoDao) AddPhoto(conf *config.Config, photo *model.Photo, maxPhotos int) (bool, error) {
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	if _, ok := p.m.users[photo.Userid]; !ok {
+		return false, model.NewNotFoundError("User %d does not exist", photo.Userid)
+	}
+	photos := p.m.userPhotos(photo.Userid)
+	if len(photos) >= maxPhotos {
+		return false, nil
+	}
+	p.m.nextPhotoId++
+	photo.Id = p.m.nextPhotoId
+	photo.Position = len(photos)
+	stored := *photo
+	p.m.photos[stored.Id] = &stored
+	return true, nil
+}
+
+// userPhotos returns the photos of userId ordered by position, the caller
+// holds the lock.
+func (m *MemoryStorage) userPhotos(userId int64) []*model.Photo {
+	photos := []*model.Photo{}
+	for _, photo := range m.photos {
+		if photo.Userid == userId {
+			photos = append(photos, photo)
+		}
+	}
+	sort.Sort(photosByPosition(photos))
+	return photos
+}
+
+func (p *MemoryPhotoDao) GetPhotos(conf *config.Config, userId int64) ([]model.Photo, error) {
+	return p.GetPhotosByUserIds(conf, []int64{userId})
+}
+
+func (p *MemoryPhotoDao) GetPhotosByUserIds(conf *config.Config, userIds []int64) ([]model.Photo, error) {
+	p.m.mu.RLock()
+	defer p.m.mu.RUnlock()
+	photos := []model.Photo{}
+	for _, userId := range userIds {
+		for _, photo := range p.m.userPhotos(userId) {
+			photos = append(photos, *photo)
+		}
+	}
+	return photos, nil
+}
+
+func (p *MemoryPhotoDao) DeletePhoto(conf *config.Config, userId int64, photoId int64) (*model.Photo, error) {
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	stored, ok := p.m.photos[photoId]
+	if !ok || stored.Userid != userId {
+		return nil, model.NewNotFoundError("Photo %d does not exist", photoId)
+	}
+	delete(p.m.photos, photoId)
+	for _, photo := range p.m.userPhotos(userId) {
+		if photo.Position > stored.Position {
+			photo.Position--
+		}
+	}
+	photo := *stored
+	return &photo, nil
+}
+
+func (p *MemoryPhotoDao) ReorderPhotos(conf *config.Config, userId int64, photoIds []int64) error {
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	if _, ok := p.m.users[userId]; !ok {
+		return model.NewNotFoundError("User %d does not exist", userId)
+	}
+	ids := []int64{}
+	for _, photo := range p.m.userPhotos(userId) {
+		ids = append(ids, photo.Id)
+	}
+	if !samePhotoIds(ids, photoIds) {
+		return model.NewValidationError("Photo ids must list every photo of the user once! ")
+	}
+	for position, id := range photoIds {
+		p.m.photos[id].Position = position
+	}
+	return nil
+}
+
+type photosByPosition []*model.Photo
+
+func (s photosByPosition) Len() int           { return len(s) }
+func (s photosByPosition) Less(i, j int) bool { return s[i].Position < s[j].Position }
+func (s photosByPosition) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type MemoryConversationDao struct {
+	m *MemoryStorage
+}
+
+func (d *MemoryConversationDao) GetConversation(conf *config.Config, id int64) (*model.Conversation, error) {
+	d.m.mu.RLock()
+	defer d.m.mu.RUnlock()
+	conversation, ok := d.m.conversations[id]
+	if !ok {
+		return nil, model.NewNotFoundError("Match %d does not exist", id)
+	}
+	result := *conversation
+	return &result, nil
+}
+
+func (d *MemoryConversationDao) GetOpenConversations(conf *config.Config, userId int64, page model.Page) ([]model.Conversation, error) {
+	d.m.mu.RLock()
+	defer d.m.mu.RUnlock()
+	conversations := []model.Conversation{}
+	for _, conversation := range d.m.conversations {
+		if conversation.HasMember(userId) && conversation.IsOpen() && conversation.Id > page.AfterId {
+			conversations = append(conversations, *conversation)
+		}
+	}
+	sort.Sort(conversationsById(conversations))
+	if len(conversations) > page.Limit {
+		conversations = conversations[:page.Limit]
+	}
+	return conversations, nil
+}
+
+func (d *MemoryConversationDao) CountUnread(conf *config.Config, userId int64, conversationIds []int64) (map[int64]int, error) {
+	d.m.mu.RLock()
+	defer d.m.mu.RUnlock()
+	result := make(map[int64]int)
+	for _, id := range conversationIds {
+		conversation, ok := d.m.conversations[id]
+		if !ok {
+			continue
+		}
+		lastRead := conversation.LastReadBy(userId)
+		for _, message := range d.m.messages[id] {
+			if message.Senderid != userId && message.Id > lastRead {
+				result[id]++
+			}
+		}
+	}
+	return result, nil
+}
+
+func (d *MemoryConversationDao) AddMessage(conf *config.Config, message *model.Message, events []model.Event) (bool, error) {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	conversation, ok := d.m.conversations[message.Conversationid]
+	if !ok {
+		return false, model.NewNotFoundError("Match %d does not exist", message.Conversationid)
+	}
+	if !conversation.IsOpen() {
+		return false, nil
+	}
+	d.m.nextMessageId++
+	message.Id = d.m.nextMessageId
+	d.m.messages[conversation.Id] = append(d.m.messages[conversation.Id], *message)
+	conversation.LastMessageAt = message.CreatedAt
+	d.m.deliver(events)
+	return true, nil
+}
+
+func (d *MemoryConversationDao) GetMessages(conf *config.Config, conversationId int64, page model.Page) ([]model.Message, error) {
+	d.m.mu.RLock()
+	defer d.m.mu.RUnlock()
+	stored := d.m.messages[conversationId]
+	messages := []model.Message{}
+	for i := len(stored) - 1; i >= 0 && len(messages) < page.Limit; i-- {
+		if page.AfterId == 0 || stored[i].Id < page.AfterId {
+			messages = append(messages, stored[i])
+		}
+	}
+	return messages, nil
+}
+
+func (d *MemoryConversationDao) MarkRead(conf *config.Config, conversationId int64, userId int64, messageId int64) (bool, error) {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	conversation, ok := d.m.conversations[conversationId]
+	if !ok {
+		return false, nil
+	}
+	found := false
+	for _, message := range d.m.messages[conversationId] {
+		if message.Id == messageId {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false, nil
+	}
+	if userId == conversation.Userid && messageId > conversation.UserLastReadId {
+		conversation.UserLastReadId = messageId
+	}
+	if userId == conversation.Otheruserid && messageId > conversation.OtherUserLastReadId {
+		conversation.OtherUserLastReadId = messageId
+	}
+	return true, nil
+}
+
+type conversationsById []model.Conversation
+
+func (s conversationsById) Len() int           { return len(s) }
+func (s conversationsById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s conversationsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// MemoryEventDao delivers the events to the sink of this process, there is
+// no other instance sharing the storage.
+type MemoryEventDao struct {
+	m *MemoryStorage
+}
+
+func (d *MemoryEventDao) Listen(conf *config.Config, sink EventSink) {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	d.m.sink = sink
+}
+
+func (d *MemoryEventDao) Close() error {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	d.m.sink = nil
+	return nil
+}
+
+// deliver hands events to the sink, in the order of the writes since the
+// caller holds the write lock.
+func (m *MemoryStorage) deliver(events []model.Event) {
+	if m.sink == nil {
+		return
+	}
+	for _, e := range events {
+		m.sink.Publish(e)
+	}
+}
+
+// queueWebhookEvent adds one pending delivery of the event for every webhook
+// subscribed to eventType. The caller holds the write lock.
+func (m *MemoryStorage) queueWebhookEvent(eventType model.WebhookEventType, data interface{}, now time.Time) error {
+	payload, err := webhookPayload(eventType, data, now)
+	if err != nil {
+		return err
+	}
+	for _, webhook := range m.webhooks {
+		for _, t := range webhook.EventTypes {
+			if t != string(eventType) {
+				continue
+			}
+			m.nextDeliveryId++
+			m.deliveries[m.nextDeliveryId] = &model.WebhookDelivery{Id: m.nextDeliveryId, Webhookid: webhook.Id, EventType: eventType,
+				Payload: payload, Status: model.DeliveryPending, NextAttemptAt: now, CreatedAt: now}
+			break
+		}
+	}
+	return nil
+}
+
+type MemoryWebhookDao struct {
+	m *MemoryStorage
+}
+
+func (d *MemoryWebhookDao) AddWebhook(conf *config.Config, webhook *model.Webhook) error {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	d.m.nextWebhookId++
+	webhook.Id = d.m.nextWebhookId
+	stored := *webhook
+	d.m.webhooks[stored.Id] = &stored
+	return nil
+}
+
+func (d *MemoryWebhookDao) GetWebhooks(conf *config.Config) ([]model.Webhook, error) {
+	d.m.mu.RLock()
+	defer d.m.mu.RUnlock()
+	webhooks := []model.Webhook{}
+	for _, webhook := range d.m.webhooks {
+		webhooks = append(webhooks, *webhook)
+	}
+	sort.Sort(webhooksById(webhooks))
+	return webhooks, nil
+}
+
+func (d *MemoryWebhookDao) DeleteWebhook(conf *config.Config, id int64) (bool, error) {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	if _, ok := d.m.webhooks[id]; !ok {
+		return false, nil
+	}
+	delete(d.m.webhooks, id)
+	for deliveryId, delivery := range d.m.deliveries {
+		if delivery.Webhookid == id {
+			delete(d.m.deliveries, deliveryId)
+		}
+	}
+	return true, nil
+}
+
+func (d *MemoryWebhookDao) ClaimDeliveries(conf *config.Config, now time.Time, lease time.Duration, limit int) ([]model.WebhookDelivery, error) {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	deliveries := []model.WebhookDelivery{}
+	for _, delivery := range d.m.deliveries {
+		if delivery.Status == model.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+	sort.Sort(deliveriesById(deliveries))
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	for _, delivery := range deliveries {
+		d.m.deliveries[delivery.Id].NextAttemptAt = now.Add(lease)
+	}
+	return deliveries, nil
+}
+
+func (d *MemoryWebhookDao) UpdateDelivery(conf *config.Config, delivery *model.WebhookDelivery) error {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	if _, ok := d.m.deliveries[delivery.Id]; ok {
+		stored := *delivery
+		d.m.deliveries[delivery.Id] = &stored
+	}
+	return nil
+}
+
+func (d *MemoryWebhookDao) GetDelivery(conf *config.Config, id int64) (*model.WebhookDelivery, error) {
+	d.m.mu.RLock()
+	defer d.m.mu.RUnlock()
+	delivery, ok := d.m.deliveries[id]
+	if !ok {
+		return nil, model.NewNotFoundError("Webhook delivery %d does not exist", id)
+	}
+	result := *delivery
+	return &result, nil
+}
+
+func (d *MemoryWebhookDao) GetDeliveries(conf *config.Config, status model.DeliveryStatus, page model.Page) ([]model.WebhookDelivery, error) {
+	d.m.mu.RLock()
+	defer d.m.mu.RUnlock()
+	deliveries := []model.WebhookDelivery{}
+	for _, delivery := range d.m.deliveries {
+		if (status == "" || delivery.Status == status) && (page.AfterId == 0 || delivery.Id < page.AfterId) {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+	sort.Sort(sort.Reverse(deliveriesById(deliveries)))
+	if len(deliveries) > page.Limit {
+		deliveries = deliveries[:page.Limit]
+	}
+	return deliveries, nil
+}
+
+func (d *MemoryWebhookDao) ReplayDelivery(conf *config.Config, id int64, now time.Time) (bool, error) {
+	d.m.mu.Lock()
+	defer d.m.mu.Unlock()
+	delivery, ok := d.m.deliveries[id]
+	if !ok || delivery.Status != model.DeliveryFailed {
+		return false, nil
+	}
+	delivery.Status, delivery.Attempts, delivery.NextAttemptAt = model.DeliveryPending, 0, now
+	return true, nil
+}
+
+type webhooksById []model.Webhook
+
+func (s webhooksById) Len() int           { return len(s) }
+func (s webhooksById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s webhooksById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type deliveriesById []model.WebhookDelivery
+
+func (s deliveriesById) Len() int           { return len(s) }
+func (s deliveriesById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s deliveriesById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
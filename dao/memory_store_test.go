@@ -0,0 +1,92 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	events []model.Event
+}
+
+func (s *recordingSink) Publish(e model.Event) { s.events = append(s.events, e) }
+func (s *recordingSink) Interrupt()            {}
+
+func TestMemoryTransactionRollsBack(t *testing.T) {
+	conf := &config.Config{}
+	m := NewMemoryStorage()
+	sink := &recordingSink{}
+	m.sink = sink
+	users := &MemoryUserDao{m: m}
+	relations := &MemoryRelationDao{m: m}
+	webhooks := &MemoryWebhookDao{m: m}
+	if err := webhooks.AddWebhook(conf, &model.Webhook{Url: "http://localhost/hook",
+		EventTypes: []string{string(model.WebhookSwipe), string(model.WebhookMatchCreated)}}); err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := &model.User{Name: "alice"}, &model.User{Name: "bob"}
+	for _, user := range []*model.User{alice, bob} {
+		if _, err := users.AddUser(conf, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := relations.AddOrUpdateRelation(conf, &model.Relation{Userid: bob.Id, Otheruserid: alice.Id, Status: model.RelationLike}); err != nil {
+		t.Fatal(err)
+	}
+	before := m.saveRelations()
+
+	// Alice likes bob back, but bob is deleted meanwhile: the like was
+	// counted, his row flipped to matched and a conversation opened before
+	// her row fails to be written.
+	failed := errors.New("user deleted")
+	err := relations.RunInTransaction(conf, func(store RelationStore) error {
+		now := time.Now()
+		if _, _, err := store.AddDailyLike(conf, alice.Id, now, 10); err != nil {
+			return err
+		}
+		reverse, err := store.GetRelationByUserIdPairs(conf, bob.Id, alice.Id)
+		if err != nil {
+			return err
+		}
+		reverse.Status = model.RelationMatched
+		if err := store.UpdateRelation(conf, reverse); err != nil {
+			return err
+		}
+		if _, err := store.OpenConversation(conf, alice.Id, bob.Id, now); err != nil {
+			return err
+		}
+		if _, err := store.AddBlock(conf, alice.Id, bob.Id); err != nil {
+			return err
+		}
+		if err := store.NotifyEvents(conf, []model.Event{{Type: model.EventMatch, UserId: alice.Id, OtherUserId: bob.Id}}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("got error %v, want %v", err, failed)
+	}
+	if !reflect.DeepEqual(m.saveRelations(), before) {
+		t.Error("the writes of the failed transaction were kept")
+	}
+	if len(sink.events) != 0 {
+		t.Errorf("the failed transaction notified %d events", len(sink.events))
+	}
+
+	// The ids of the failed writes are not reused, the store keeps working.
+	relation := &model.Relation{Userid: alice.Id, Otheruserid: bob.Id, Status: model.RelationLike}
+	if err := relations.RunInTransaction(conf, func(store RelationStore) error {
+		_, err := store.AddOrUpdateRelation(conf, relation)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := relations.GetRelationByUserIdPairs(conf, alice.Id, bob.Id); err != nil || stored.Id != relation.Id {
+		t.Errorf("got relation %v, error %v after a committed transaction", stored, err)
+	}
+}
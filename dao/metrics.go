@@ -0,0 +1,55 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/metrics"
+
+	"log/slog"
+	"time"
+)
+
+var queryDuration = metrics.NewHistogramVec("db_query_duration_seconds",
+	"Latency of the database calls made by each DAO method.", metrics.DefBuckets, "method")
+
+var listenerReconnectsTotal = metrics.NewCounterVec("event_listener_reconnects_total",
+	"Number of times the event listener connection was opened again after being lost.")
+
+// observeQuery records the latency of a DAO method and logs it when it is
+// slower than conf.SlowQueryMs, use it as
+// defer observeQuery(conf, "UserDao.AddUser", time.Now()).
+func observeQuery(conf *config.Config, method string, start time.Time) {
+	elapsed := time.Since(start)
+	queryDuration.Observe(elapsed.Seconds(), method)
+	if conf.SlowQueryMs > 0 && elapsed > time.Duration(conf.SlowQueryMs)*time.Millisecond {
+		slog.Warn("slow database call", "method", method, "latency_ms", float64(elapsed)/float64(time.Millisecond))
+	}
+}
+
+// registerPoolMetrics exposes the stats of the PostgreSQL connection pool,
+// read from the memory of the driver. They are left out when the driver
+// does not provide them.
+func registerPoolMetrics(conf *config.Config) {
+	if _, err := GetPoolStats(conf); err != nil {
+		slog.Warn("database pool metrics are disabled", "error", err.Error())
+		return
+	}
+	poolStat := func(get func(s *PoolStats) uint32) func() float64 {
+		return func() float64 {
+			// Reading the stats can not fail once it worked.
+			stats, _ := GetPoolStats(conf)
+			return float64(get(stats))
+		}
+	}
+	metrics.NewGaugeFunc("pg_pool_size", "Maximum number of connections in the pool.",
+		func() float64 { return float64(conf.PgPoolsize) })
+	metrics.NewGaugeFunc("pg_pool_conns", "Number of connections open in the pool.",
+		poolStat(func(s *PoolStats) uint32 { return s.TotalConns }))
+	metrics.NewGaugeFunc("pg_pool_free_conns", "Number of open connections waiting for a query.",
+		poolStat(func(s *PoolStats) uint32 { return s.FreeConns }))
+	metrics.NewCounterFunc("pg_pool_requests_total", "Number of connections asked to the pool.",
+		poolStat(func(s *PoolStats) uint32 { return s.Requests }))
+	metrics.NewCounterFunc("pg_pool_hits_total", "Number of connections asked to the pool and found free.",
+		poolStat(func(s *PoolStats) uint32 { return s.Hits }))
+	metrics.NewCounterFunc("pg_pool_timeouts_total", "Number of connections asked to the pool which did not come in time.",
+		poolStat(func(s *PoolStats) uint32 { return s.Timeouts }))
+}
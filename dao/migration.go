@@ -0,0 +1,318 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	pg "gopkg.in/pg.v4"
+
+	"fmt"
+	"time"
+)
+
+// migrationLockId is the key of the advisory lock taken while migrating so
+// that two instances started at once do not apply the same migration twice.
+const migrationLockId = 7210425
+
+// Migration is one numbered, reversible step of the database schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrations must stay ordered by Version and released migrations must never
+// be edited; add a new one instead.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create users",
+		Up:      `CREATE TABLE IF NOT EXISTS users (id bigserial PRIMARY key , name CHARACTER VARYING)`,
+		Down:    `DROP TABLE users`,
+	},
+	{
+		Version: 2,
+		Name:    "create relations",
+		Up:      `CREATE TABLE IF NOT EXISTS relations (id bigserial PRIMARY key , userid bigint, otheruserid bigint, status smallint)`,
+		Down:    `DROP TABLE relations`,
+	},
+	{
+		// Duplicate relations make the migration fail, the repair command
+		// lists them and keeps the latest one of each pair on demand.
+		Version: 3,
+		Name:    "unique relations user pair",
+		Up: `DO $$ BEGIN
+				IF EXISTS (SELECT 1 FROM relations GROUP BY userid, otheruserid HAVING count(*) > 1) THEN
+					RAISE EXCEPTION 'duplicate relations, list them with "repair dry-run" and fix them or run "repair"';
+				END IF;
+			END $$;
+			ALTER TABLE relations ADD CONSTRAINT relations_userid_otheruserid_key UNIQUE (userid, otheruserid)`,
+		Down: `ALTER TABLE relations DROP CONSTRAINT relations_userid_otheruserid_key`,
+	},
+	{
+		Version: 4,
+		Name:    "index relations for keyset pagination",
+		Up:      `CREATE INDEX relations_userid_id_idx ON relations (userid, id)`,
+		Down:    `DROP INDEX relations_userid_id_idx`,
+	},
+	{
+		Version: 5,
+		Name:    "index incoming relations",
+		Up:      `CREATE INDEX relations_otheruserid_id_idx ON relations (otheruserid, id)`,
+		Down:    `DROP INDEX relations_otheruserid_id_idx`,
+	},
+	{
+		// Duplicate names make the migration fail, the repair command lists
+		// them and renames them on demand.
+		Version: 6,
+		Name:    "unique user names",
+		Up: `DO $$ BEGIN
+				IF EXISTS (SELECT 1 FROM users GROUP BY name HAVING count(*) > 1) THEN
+					RAISE EXCEPTION 'duplicate user names, list them with "repair dry-run" and fix them or run "repair"';
+				END IF;
+			END $$;
+			ALTER TABLE users ADD CONSTRAINT users_name_key UNIQUE (name)`,
+		Down: `ALTER TABLE users DROP CONSTRAINT users_name_key`,
+	},
+	{
+		// The constraints are NOT VALID so that databases which already hold
+		// orphaned relations can migrate; the repair command cleans them up
+		// and validates the constraints.
+		Version: 7,
+		Name:    "relations reference users",
+		Up: `ALTER TABLE relations ADD CONSTRAINT relations_userid_fkey FOREIGN KEY (userid) REFERENCES users (id) ON DELETE CASCADE NOT VALID;
+			ALTER TABLE relations ADD CONSTRAINT relations_otheruserid_fkey FOREIGN KEY (otheruserid) REFERENCES users (id) ON DELETE CASCADE NOT VALID`,
+		Down: `ALTER TABLE relations DROP CONSTRAINT relations_otheruserid_fkey;
+			ALTER TABLE relations DROP CONSTRAINT relations_userid_fkey`,
+	},
+	{
+		Version: 8,
+		Name:    "user passwords",
+		Up:      `ALTER TABLE users ADD COLUMN password_hash CHARACTER VARYING NOT NULL DEFAULT ''`,
+		Down:    `ALTER TABLE users DROP COLUMN password_hash`,
+	},
+	{
+		Version: 9,
+		Name:    "daily like quota",
+		Up: `ALTER TABLE users ADD COLUMN premium BOOLEAN NOT NULL DEFAULT false;
+			CREATE TABLE daily_likes (userid bigint REFERENCES users (id) ON DELETE CASCADE, day date, likes integer NOT NULL, PRIMARY KEY (userid, day))`,
+		Down: `DROP TABLE daily_likes;
+			ALTER TABLE users DROP COLUMN premium`,
+	},
+	{
+		Version: 10,
+		Name:    "create blocks",
+		Up: `CREATE TABLE blocks (userid bigint REFERENCES users (id) ON DELETE CASCADE, blockeduserid bigint REFERENCES users (id) ON DELETE CASCADE, PRIMARY KEY (userid, blockeduserid));
+			CREATE INDEX blocks_blockeduserid_idx ON blocks (blockeduserid, userid)`,
+		Down: `DROP TABLE blocks`,
+	},
+	{
+		Version: 11,
+		Name:    "user profiles",
+		Up: `ALTER TABLE users ADD COLUMN birth_date DATE, ADD COLUMN gender CHARACTER VARYING NOT NULL DEFAULT '',
+			ADD COLUMN interested_in CHARACTER VARYING[], ADD COLUMN bio TEXT NOT NULL DEFAULT '', ADD COLUMN last_active TIMESTAMPTZ`,
+		Down: `ALTER TABLE users DROP COLUMN birth_date, DROP COLUMN gender, DROP COLUMN interested_in, DROP COLUMN bio, DROP COLUMN last_active`,
+	},
+	{
+		Version: 12,
+		Name:    "user locations",
+		Up: `ALTER TABLE users ADD COLUMN latitude DOUBLE PRECISION, ADD COLUMN longitude DOUBLE PRECISION;
+			CREATE INDEX users_latitude_longitude_idx ON users (latitude, longitude)`,
+		Down: `DROP INDEX users_latitude_longitude_idx;
+			ALTER TABLE users DROP COLUMN latitude, DROP COLUMN longitude`,
+	},
+	{
+		Version: 13,
+		Name:    "create photos",
+		Up: `CREATE TABLE photos (id bigserial PRIMARY KEY, userid bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			position integer NOT NULL, key CHARACTER VARYING NOT NULL, thumbnail_key CHARACTER VARYING NOT NULL,
+			content_type CHARACTER VARYING NOT NULL, width integer NOT NULL, height integer NOT NULL, created_at TIMESTAMPTZ NOT NULL);
+			CREATE INDEX photos_userid_position_idx ON photos (userid, position)`,
+		Down: `DROP TABLE photos`,
+	},
+	{
+		// Pairs matched before the upgrade get an open conversation, status 2
+		// is model.RelationMatched.
+		Version: 14,
+		Name:    "create conversations and messages",
+		Up: `CREATE TABLE conversations (id bigserial PRIMARY KEY, userid bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			otheruserid bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE, user_last_read_id bigint NOT NULL DEFAULT 0,
+			other_user_last_read_id bigint NOT NULL DEFAULT 0, created_at TIMESTAMPTZ NOT NULL, closed_at TIMESTAMPTZ, last_message_at TIMESTAMPTZ);
+			CREATE UNIQUE INDEX conversations_open_pair_idx ON conversations (userid, otheruserid) WHERE closed_at IS NULL;
+			CREATE INDEX conversations_otheruserid_idx ON conversations (otheruserid);
+			CREATE TABLE messages (id bigserial PRIMARY KEY, conversationid bigint NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+			senderid bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE, body TEXT NOT NULL, created_at TIMESTAMPTZ NOT NULL);
+			CREATE INDEX messages_conversationid_id_idx ON messages (conversationid, id);
+			INSERT INTO conversations (userid, otheruserid, created_at)
+			SELECT userid, otheruserid, now() FROM relations WHERE status = 2 AND userid < otheruserid`,
+		Down: `DROP TABLE messages;
+			DROP TABLE conversations`,
+	},
+	{
+		Version: 15,
+		Name:    "create webhooks and their deliveries",
+		Up: `CREATE TABLE webhooks (id bigserial PRIMARY KEY, url CHARACTER VARYING NOT NULL, event_types text[] NOT NULL,
+			secret CHARACTER VARYING NOT NULL, created_at TIMESTAMPTZ NOT NULL);
+			CREATE TABLE webhook_deliveries (id bigserial PRIMARY KEY, webhookid bigint NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+			event_type CHARACTER VARYING NOT NULL, payload TEXT NOT NULL, status CHARACTER VARYING NOT NULL, attempts integer NOT NULL DEFAULT 0,
+			next_attempt_at TIMESTAMPTZ NOT NULL, last_status integer NOT NULL DEFAULT 0, last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL, delivered_at TIMESTAMPTZ);
+			CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+			CREATE INDEX webhook_deliveries_status_id_idx ON webhook_deliveries (status, id)`,
+		Down: `DROP TABLE webhook_deliveries;
+			DROP TABLE webhooks`,
+	},
+	{
+		Version: 16,
+		Name:    "user location times",
+		Up:      `ALTER TABLE users ADD COLUMN located_at TIMESTAMPTZ`,
+		Down:    `ALTER TABLE users DROP COLUMN located_at`,
+	},
+}
+
+// MigrationStatus describes whether a migration has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type schemaMigration struct {
+	TableName struct{} `sql:"schema_migrations"`
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func createMigrationTable(db *pg.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY key, name CHARACTER VARYING, applied_at timestamptz NOT NULL DEFAULT now())`)
+	return err
+}
+
+func appliedMigrations(db *pg.DB) (map[int]schemaMigration, error) {
+	var rows []schemaMigration
+	_, err := db.Query(&rows, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// MigrateUp applies every pending migration in order and returns the ones
+// it applied.
+func MigrateUp(conf *config.Config) ([]Migration, error) {
+	c := NewPostgreConnector(conf)
+	if err := createMigrationTable(c.DB); err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range migrations {
+		m := m
+		applied := false
+		err := c.DB.RunInTransaction(func(tx *pg.Tx) error {
+			if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationLockId); err != nil {
+				return err
+			}
+			var count int
+			if _, err := tx.QueryOne(pg.Scan(&count), `SELECT count(*) FROM schema_migrations WHERE version = ?`, m.Version); err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+			if _, err := tx.Exec(m.Up); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+				return err
+			}
+			applied = true
+			return nil
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %s", m.Version, m.Name, err.Error())
+		}
+		if applied {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the n most recently applied migrations and returns
+// the ones it reverted.
+func MigrateDown(conf *config.Config, n int) ([]Migration, error) {
+	c := NewPostgreConnector(conf)
+	if err := createMigrationTable(c.DB); err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < n; i-- {
+		m := migrations[i]
+		reverted := false
+		err := c.DB.RunInTransaction(func(tx *pg.Tx) error {
+			if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationLockId); err != nil {
+				return err
+			}
+			res, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version)
+			if err != nil {
+				return err
+			}
+			if res.Affected() == 0 {
+				return nil
+			}
+			if _, err := tx.Exec(m.Down); err != nil {
+				return err
+			}
+			reverted = true
+			return nil
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %s", m.Version, m.Name, err.Error())
+		}
+		if reverted {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// LatestSchemaVersion is the version the schema has once every known
+// migration is applied.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// GetSchemaVersion returns the version of the most recent applied migration,
+// 0 when none is.
+func GetSchemaVersion(conf *config.Config) (int, error) {
+	c := NewPostgreConnector(conf)
+	var version int
+	_, err := c.DB.QueryOne(pg.Scan(&version), `SELECT coalesce(max(version), 0) FROM schema_migrations`)
+	if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == "42P01" {
+		// undefined_table: no migration was ever run.
+		return 0, nil
+	}
+	return version, wrapError(err, "Fail to get schema version")
+}
+
+// GetMigrationStatus lists every known migration with its applied state.
+func GetMigrationStatus(conf *config.Config) ([]MigrationStatus, error) {
+	c := NewPostgreConnector(conf)
+	if err := createMigrationTable(c.DB); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(c.DB)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		row, ok := applied[m.Version]
+		status = append(status, MigrationStatus{Migration: m, Applied: ok, AppliedAt: row.AppliedAt})
+	}
+	return status, nil
+}
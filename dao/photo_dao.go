@@ -0,0 +1,141 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+	pg "gopkg.in/pg.v4"
+
+	"time"
+)
+
+type PhotoDao struct {
+}
+
+// lockUserPhotos locks the row of user userId until the end of tx, which
+// serializes the changes to the positions of its photos.
+func lockUserPhotos(tx *pg.Tx, userId int64) error {
+	var id int64
+	_, err := tx.QueryOne(pg.Scan(&id), `SELECT id FROM users WHERE id = ? FOR UPDATE`, userId)
+	if err == pg.ErrNoRows {
+		return model.NewNotFoundError("User %d does not exist", userId)
+	}
+	return err
+}
+
+func (p *PhotoDao) AddPhoto(conf *config.Config, photo *model.Photo, maxPhotos int) (bool, error) {
+	defer observeQuery(conf, "PhotoDao.AddPhoto", time.Now())
+	c := NewPostgreConnector(conf)
+	added := false
+	err := c.DB.RunInTransaction(func(tx *pg.Tx) error {
+		added = false
+		if err := lockUserPhotos(tx, photo.Userid); err != nil {
+			return err
+		}
+		var count int
+		if _, err := tx.QueryOne(pg.Scan(&count), `SELECT count(*) FROM photos WHERE userid = ?`, photo.Userid); err != nil {
+			return err
+		}
+		if count >= maxPhotos {
+			return nil
+		}
+		photo.Position = count
+		if _, err := tx.Model(photo).Create(); err != nil {
+			return err
+		}
+		added = true
+		return nil
+	})
+	return added, wrapError(err, "Fail to add photo of user %d", photo.Userid)
+}
+
+func (p *PhotoDao) GetPhotos(conf *config.Config, userId int64) ([]model.Photo, error) {
+	defer observeQuery(conf, "PhotoDao.GetPhotos", time.Now())
+	c := NewPostgreConnector(conf)
+	photos := []model.Photo{}
+	err := c.DB.Model(&photos).Where("userid = ?", userId).Order("position").Select()
+	if err != nil {
+		return nil, wrapError(err, "Fail to get photos of user %d", userId)
+	}
+	return photos, nil
+}
+
+func (p *PhotoDao) GetPhotosByUserIds(conf *config.Config, userIds []int64) ([]model.Photo, error) {
+	defer observeQuery(conf, "PhotoDao.GetPhotosByUserIds", time.Now())
+	photos := []model.Photo{}
+	if len(userIds) == 0 {
+		return photos, nil
+	}
+	c := NewPostgreConnector(conf)
+	err := c.DB.Model(&photos).Where("userid IN (?)", pg.Ints(userIds)).Order("userid", "position").Select()
+	if err != nil {
+		return nil, wrapError(err, "Fail to get photos of %d users", len(userIds))
+	}
+	return photos, nil
+}
+
+func (p *PhotoDao) DeletePhoto(conf *config.Config, userId int64, photoId int64) (*model.Photo, error) {
+	defer observeQuery(conf, "PhotoDao.DeletePhoto", time.Now())
+	c := NewPostgreConnector(conf)
+	photo := &model.Photo{}
+	err := c.DB.RunInTransaction(func(tx *pg.Tx) error {
+		if err := lockUserPhotos(tx, userId); err != nil {
+			return err
+		}
+		_, err := tx.QueryOne(photo, `DELETE FROM photos WHERE id = ? AND userid = ? RETURNING *`, photoId, userId)
+		if err == pg.ErrNoRows {
+			return model.NewNotFoundError("Photo %d does not exist", photoId)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE photos SET position = position - 1 WHERE userid = ? AND position > ?`, userId, photo.Position)
+		return err
+	})
+	if err != nil {
+		return nil, wrapError(err, "Fail to delete photo %d", photoId)
+	}
+	return photo, nil
+}
+
+func (p *PhotoDao) ReorderPhotos(conf *config.Config, userId int64, photoIds []int64) error {
+	defer observeQuery(conf, "PhotoDao.ReorderPhotos", time.Now())
+	c := NewPostgreConnector(conf)
+	err := c.DB.RunInTransaction(func(tx *pg.Tx) error {
+		if err := lockUserPhotos(tx, userId); err != nil {
+			return err
+		}
+		var ids pg.Ints
+		if _, err := tx.Query(&ids, `SELECT id FROM photos WHERE userid = ?`, userId); err != nil {
+			return err
+		}
+		if !samePhotoIds(ids, photoIds) {
+			return model.NewValidationError("Photo ids must list every photo of the user once! ")
+		}
+		for position, id := range photoIds {
+			if _, err := tx.Exec(`UPDATE photos SET position = ? WHERE id = ?`, position, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wrapError(err, "Fail to reorder photos of user %d", userId)
+}
+
+// samePhotoIds reports whether ordered lists the ids of stored exactly once,
+// in any order.
+func samePhotoIds(stored []int64, ordered []int64) bool {
+	if len(stored) != len(ordered) {
+		return false
+	}
+	remaining := make(map[int64]bool, len(stored))
+	for _, id := range stored {
+		remaining[id] = true
+	}
+	for _, id := range ordered {
+		if !remaining[id] {
+			return false
+		}
+		delete(remaining, id)
+	}
+	return true
+}
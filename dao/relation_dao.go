@@ -3,49 +3,207 @@ package dao
 import (
 	"github.com/tangyang/simple-http-server/config"
 	"github.com/tangyang/simple-http-server/model"
+	pg "gopkg.in/pg.v4"
 
-	"fmt"
+	"time"
 )
 
+// dayLayout formats days as PostgreSQL date literals.
+const dayLayout = "2006-01-02"
+
 type RelationDao struct {
+	tx *pg.Tx
 }
 
-func (r *RelationDao) CreateRelationSchema(conf *config.Config) error {
+func (r *RelationDao) RunInTransaction(conf *config.Config, fn func(RelationStore) error) error {
+	defer observeQuery(conf, "RelationDao.RunInTransaction", time.Now())
+	if r.tx != nil {
+		return fn(r)
+	}
 	c := NewPostgreConnector(conf)
-	_, err := c.DB.Exec("CREATE TABLE relations (id bigserial PRIMARY key , userid bigint, otheruserid bigint, status smallint)")
-	return err
+	err := c.DB.RunInTransaction(func(tx *pg.Tx) error {
+		return fn(&RelationDao{tx: tx})
+	})
+	return wrapError(err, "Fail to run relation transaction")
+}
+
+// LockUserPair takes a transaction scoped advisory lock on the unordered
+// pair of users, so concurrent swipes between them are evaluated one after
+// another. It must be called inside RunInTransaction. Ids are truncated to
+// the two int4 lock keys, a collision only costs some extra serialization.
+func (r *RelationDao) LockUserPair(conf *config.Config, userId int64, otherUserId int64) error {
+	defer observeQuery(conf, "RelationDao.LockUserPair", time.Now())
+	if r.tx == nil {
+		return model.NewError(model.ErrorInternal, nil, "LockUserPair called outside of a transaction")
+	}
+	if userId > otherUserId {
+		userId, otherUserId = otherUserId, userId
+	}
+	_, err := r.tx.Exec(`SELECT pg_advisory_xact_lock(?::int4, ?::int4)`, int32(userId), int32(otherUserId))
+	return wrapError(err, "Fail to lock relations between user %d and user %d", userId, otherUserId)
 }
 
+// AddOrUpdateRelation inserts the relation, or overwrites the status of the
+// existing row for the same pair of users. It reports whether a row was
+// created.
 func (r *RelationDao) AddOrUpdateRelation(conf *config.Config, relation *model.Relation) (bool, error) {
-	c := NewPostgreConnector(conf)
-	b, err := c.DB.Model(relation).Where("userid=? and otheruserid=?", relation.Userid, relation.Otheruserid).SelectOrCreate()
-	return b, err
+	defer observeQuery(conf, "RelationDao.AddOrUpdateRelation", time.Now())
+	db := getDB(conf, r.tx)
+	existing := &model.Relation{}
+	err := db.Model(existing).Where("userid=? and otheruserid=?", relation.Userid, relation.Otheruserid).Select()
+	created := err == pg.ErrNoRows
+	if created {
+		if _, err = db.Model(relation).Create(); err != nil {
+			return false, wrapError(err, "Fail to add relation from user %d to user %d", relation.Userid, relation.Otheruserid)
+		}
+	} else if err != nil {
+		return false, wrapError(err, "Fail to get relation by user id %d and other user id %d", relation.Userid, relation.Otheruserid)
+	} else {
+		relation.Id = existing.Id
+		if err := r.UpdateRelation(conf, relation); err != nil {
+			return false, err
+		}
+	}
+	return created, queueWebhookEvent(db, model.WebhookSwipe, swipeWebhookData(relation), time.Now())
 }
 
-func (r *RelationDao) GetRelationByUserIdPairs(conf *config.Config, userId int64, otherUserId int64) *model.Relation {
-	c := NewPostgreConnector(conf)
+func (r *RelationDao) GetRelationByUserIdPairs(conf *config.Config, userId int64, otherUserId int64) (*model.Relation, error) {
+	defer observeQuery(conf, "RelationDao.GetRelationByUserIdPairs", time.Now())
+	db := getDB(conf, r.tx)
 	relation := &model.Relation{}
-	err := c.DB.Model(relation).Where("userid=? and otheruserid=?", userId, otherUserId).Select()
+	err := db.Model(relation).Where("userid=? and otheruserid=?", userId, otherUserId).Select()
 	if err != nil {
-		fmt.Sprintf("Fail to get relation by user id %d and other user id %d, error: %s\n", userId, otherUserId, err.Error())
-		return nil
+		return nil, wrapError(err, "Fail to get relation by user id %d and other user id %d", userId, otherUserId)
 	}
-	return relation
+	return relation, nil
 }
 
 func (r *RelationDao) UpdateRelation(conf *config.Config, relation *model.Relation) error {
-	c := NewPostgreConnector(conf)
-	_, err := c.DB.Model(relation).Set("status=?", relation.Status).Where("id=?", relation.Id).Update()
-	return err
+	defer observeQuery(conf, "RelationDao.UpdateRelation", time.Now())
+	db := getDB(conf, r.tx)
+	_, err := db.Model(relation).Set("status=?", relation.Status).Where("id=?", relation.Id).Update()
+	return wrapError(err, "Fail to update relation %d", relation.Id)
 }
 
-func (r *RelationDao) GetAllRelationsByUserId(conf *config.Config, userId int64) []model.Relation {
-	c := NewPostgreConnector(conf)
-	var relations []model.Relation
-	_, err := c.DB.Query(&relations, `SELECT * FROM relations where userid = ? `, userId)
+// DeleteRelation removes the relation from userId to otherUserId and reports
+// whether it existed.
+func (r *RelationDao) DeleteRelation(conf *config.Config, userId int64, otherUserId int64) (bool, error) {
+	defer observeQuery(conf, "RelationDao.DeleteRelation", time.Now())
+	db := getDB(conf, r.tx)
+	res, err := db.Exec(`DELETE FROM relations WHERE userid = ? AND otheruserid = ?`, userId, otherUserId)
+	if err != nil {
+		return false, wrapError(err, "Fail to delete relation from user %d to user %d", userId, otherUserId)
+	}
+	return res.Affected() > 0, nil
+}
+
+func (r *RelationDao) GetDailyLikes(conf *config.Config, userId int64, day time.Time) (int, error) {
+	defer observeQuery(conf, "RelationDao.GetDailyLikes", time.Now())
+	db := getDB(conf, r.tx)
+	var likes int
+	_, err := db.QueryOne(pg.Scan(&likes), `SELECT likes FROM daily_likes WHERE userid = ? AND day = ?::date`, userId, day.Format(dayLayout))
+	if err == pg.ErrNoRows {
+		return 0, nil
+	}
+	return likes, wrapError(err, "Fail to get daily likes of user %d", userId)
+}
+
+// AddDailyLike increments the counter of the day in one statement, so that
+// concurrent likes of the same user can not go over limit together.
+func (r *RelationDao) AddDailyLike(conf *config.Config, userId int64, day time.Time, limit int) (int, bool, error) {
+	defer observeQuery(conf, "RelationDao.AddDailyLike", time.Now())
+	db := getDB(conf, r.tx)
+	var likes int
+	_, err := db.QueryOne(pg.Scan(&likes), `INSERT INTO daily_likes (userid, day, likes) VALUES (?, ?::date, 1)
+		ON CONFLICT (userid, day) DO UPDATE SET likes = daily_likes.likes + 1 WHERE daily_likes.likes < ?
+		RETURNING likes`, userId, day.Format(dayLayout), limit)
+	if err == pg.ErrNoRows {
+		return limit, false, nil
+	}
+	if err != nil {
+		return 0, false, wrapError(err, "Fail to add daily like of user %d", userId)
+	}
+	return likes, true, nil
+}
+
+func (r *RelationDao) AddBlock(conf *config.Config, userId int64, blockedUserId int64) (bool, error) {
+	defer observeQuery(conf, "RelationDao.AddBlock", time.Now())
+	db := getDB(conf, r.tx)
+	res, err := db.Exec(`INSERT INTO blocks (userid, blockeduserid) VALUES (?, ?) ON CONFLICT DO NOTHING`, userId, blockedUserId)
 	if err != nil {
-		fmt.Sprintf("Fail to get all relations by userId, error: %s\n", err.Error())
-		return nil
+		return false, wrapError(err, "Fail to block user %d for user %d", blockedUserId, userId)
 	}
-	return relations
+	return res.Affected() > 0, nil
+}
+
+func (r *RelationDao) DeleteBlock(conf *config.Config, userId int64, blockedUserId int64) (bool, error) {
+	defer observeQuery(conf, "RelationDao.DeleteBlock", time.Now())
+	db := getDB(conf, r.tx)
+	res, err := db.Exec(`DELETE FROM blocks WHERE userid = ? AND blockeduserid = ?`, userId, blockedUserId)
+	if err != nil {
+		return false, wrapError(err, "Fail to unblock user %d for user %d", blockedUserId, userId)
+	}
+	return res.Affected() > 0, nil
+}
+
+// GetRelationsByUserId returns the relations swiped by userId, or the ones
+// swiping on userId for incoming filters. Incoming dislikes are never listed,
+// users are not told who turned them down.
+func (r *RelationDao) GetRelationsByUserId(conf *config.Config, userId int64, filter model.RelationFilter, page model.Page) ([]model.Relation, error) {
+	defer observeQuery(conf, "RelationDao.GetRelationsByUserId", time.Now())
+	db := getDB(conf, r.tx)
+	relations := []model.Relation{}
+	q := db.Model(&relations)
+	if filter.Direction == model.RelationIncoming {
+		q = q.Where("otheruserid = ?", userId).Where("status <> ?", model.RelationDislike)
+	} else {
+		q = q.Where("userid = ?", userId)
+	}
+	if filter.Status != model.RelationAnyStatus {
+		q = q.Where("status = ?", filter.Status)
+	}
+	err := q.Where("id > ?", page.AfterId).Order("id").Limit(page.Limit).Select()
+	if err != nil {
+		return nil, wrapError(err, "Fail to get relations by user id %d", userId)
+	}
+	return relations, nil
+}
+
+func (r *RelationDao) OpenConversation(conf *config.Config, userId int64, otherUserId int64, now time.Time) (int64, error) {
+	defer observeQuery(conf, "RelationDao.OpenConversation", time.Now())
+	db := getDB(conf, r.tx)
+	userId, otherUserId = model.ConversationPair(userId, otherUserId)
+	var id int64
+	_, err := db.QueryOne(pg.Scan(&id), `INSERT INTO conversations (userid, otheruserid, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (userid, otheruserid) WHERE closed_at IS NULL DO NOTHING RETURNING id`, userId, otherUserId, now)
+	if err == pg.ErrNoRows {
+		_, err = db.QueryOne(pg.Scan(&id), `SELECT id FROM conversations WHERE userid = ? AND otheruserid = ? AND closed_at IS NULL`,
+			userId, otherUserId)
+		return id, wrapError(err, "Fail to open conversation between user %d and user %d", userId, otherUserId)
+	}
+	if err != nil {
+		return 0, wrapError(err, "Fail to open conversation between user %d and user %d", userId, otherUserId)
+	}
+	return id, queueWebhookEvent(db, model.WebhookMatchCreated, matchWebhookData(id, userId, otherUserId), now)
+}
+
+func (r *RelationDao) CloseConversation(conf *config.Config, userId int64, otherUserId int64, now time.Time) (int64, error) {
+	defer observeQuery(conf, "RelationDao.CloseConversation", time.Now())
+	db := getDB(conf, r.tx)
+	userId, otherUserId = model.ConversationPair(userId, otherUserId)
+	var id int64
+	_, err := db.QueryOne(pg.Scan(&id), `UPDATE conversations SET closed_at = ? WHERE userid = ? AND otheruserid = ? AND closed_at IS NULL
+		RETURNING id`, now, userId, otherUserId)
+	if err == pg.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, wrapError(err, "Fail to close conversation between user %d and user %d", userId, otherUserId)
+	}
+	return id, queueWebhookEvent(db, model.WebhookMatchDeleted, matchWebhookData(id, userId, otherUserId), now)
+}
+
+func (r *RelationDao) NotifyEvents(conf *config.Config, events []model.Event) error {
+	defer observeQuery(conf, "RelationDao.NotifyEvents", time.Now())
+	return wrapError(notifyEvents(getDB(conf, r.tx), events), "Fail to notify events")
 }
@@ -0,0 +1,116 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+	pg "gopkg.in/pg.v4"
+)
+
+const orphanRelationsCondition = `NOT EXISTS (SELECT 1 FROM users u WHERE u.id = r.userid)
+	OR NOT EXISTS (SELECT 1 FROM users u WHERE u.id = r.otheruserid)`
+
+// GetOrphanRelations returns the relations referencing a user that does not
+// exist anymore.
+func GetOrphanRelations(conf *config.Config) ([]model.Relation, error) {
+	c := NewPostgreConnector(conf)
+	relations := []model.Relation{}
+	_, err := c.DB.Query(&relations, `SELECT r.* FROM relations r WHERE `+orphanRelationsCondition+` ORDER BY r.id`)
+	if err != nil {
+		return nil, wrapError(err, "Fail to get orphan relations")
+	}
+	return relations, nil
+}
+
+// DeleteOrphanRelations deletes the relations referencing a missing user and
+// then validates the foreign keys of the relations table, which new rows
+// already respect, once migration 7 added them. It returns the number of
+// deleted relations.
+func DeleteOrphanRelations(conf *config.Config) (int, error) {
+	c := NewPostgreConnector(conf)
+	var deleted int
+	err := c.DB.RunInTransaction(func(tx *pg.Tx) error {
+		res, err := tx.Exec(`DELETE FROM relations r WHERE ` + orphanRelationsCondition)
+		if err != nil {
+			return err
+		}
+		deleted = res.Affected()
+		var constraints pg.Strings
+		_, err = tx.Query(&constraints, `SELECT conname FROM pg_constraint WHERE conrelid = 'relations'::regclass
+			AND contype = 'f' AND NOT convalidated`)
+		if err != nil {
+			return err
+		}
+		for _, name := range constraints {
+			if _, err := tx.Exec(`ALTER TABLE relations VALIDATE CONSTRAINT ` + name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, wrapError(err, "Fail to delete orphan relations")
+	}
+	return deleted, nil
+}
+
+// DuplicateRelation is a user pair with several relations, by increasing id.
+type DuplicateRelation struct {
+	Userid      int64
+	Otheruserid int64
+	Ids         []int64 `pg:",array"`
+}
+
+// GetDuplicateRelations returns the user pairs with several relations, which
+// keep migration 3 from making the pairs unique.
+func GetDuplicateRelations(conf *config.Config) ([]DuplicateRelation, error) {
+	c := NewPostgreConnector(conf)
+	duplicates := []DuplicateRelation{}
+	_, err := c.DB.Query(&duplicates, `SELECT userid, otheruserid, array_agg(id ORDER BY id) AS ids FROM relations
+		GROUP BY userid, otheruserid HAVING count(*) > 1 ORDER BY userid, otheruserid`)
+	if err != nil {
+		return nil, wrapError(err, "Fail to get duplicate relations")
+	}
+	return duplicates, nil
+}
+
+// DeleteDuplicateRelations keeps the latest relation of every user pair, the
+// one holding the last swipe, and deletes the others. It returns the number
+// of deleted relations.
+func DeleteDuplicateRelations(conf *config.Config) (int, error) {
+	c := NewPostgreConnector(conf)
+	res, err := c.DB.Exec(`DELETE FROM relations a USING relations b WHERE a.userid = b.userid AND a.otheruserid = b.otheruserid AND a.id < b.id`)
+	if err != nil {
+		return 0, wrapError(err, "Fail to delete duplicate relations")
+	}
+	return res.Affected(), nil
+}
+
+// DuplicateUserName is a name shared by several users, by increasing id.
+type DuplicateUserName struct {
+	Name string
+	Ids  []int64 `pg:",array"`
+}
+
+// GetDuplicateUserNames returns the names shared by several users, which
+// keep migration 6 from making names unique.
+func GetDuplicateUserNames(conf *config.Config) ([]DuplicateUserName, error) {
+	c := NewPostgreConnector(conf)
+	duplicates := []DuplicateUserName{}
+	_, err := c.DB.Query(&duplicates, `SELECT name, array_agg(id ORDER BY id) AS ids FROM users GROUP BY name
+		HAVING count(*) > 1 ORDER BY name`)
+	if err != nil {
+		return nil, wrapError(err, "Fail to get duplicate user names")
+	}
+	return duplicates, nil
+}
+
+// RenameDuplicateUserNames appends "#<id>" to the names shared by several
+// users, except for the oldest user. It returns the number of renamed users.
+func RenameDuplicateUserNames(conf *config.Config) (int, error) {
+	c := NewPostgreConnector(conf)
+	res, err := c.DB.Exec(`UPDATE users u SET name = u.name || '#' || u.id FROM users o WHERE o.name = u.name AND o.id < u.id`)
+	if err != nil {
+		return 0, wrapError(err, "Fail to rename duplicate user names")
+	}
+	return res.Affected(), nil
+}
@@ -0,0 +1,204 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+
+	"fmt"
+	"time"
+)
+
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+// UserStore is the persistence contract used by the user service. Errors
+// returned by stores are *model.Error values; lookups of a single row return
+// an ErrorNotFound error when the row does not exist.
+type UserStore interface {
+	AddUser(conf *config.Config, user *model.User) (bool, error)
+	GetUserByName(conf *config.Config, name string) (*model.User, error)
+	GetUserById(conf *config.Config, id int64) (*model.User, error)
+	// UpdateUser writes the name, password hash and premium flag of user at
+	// once, provided its password hash is still passwordHash. A taken name
+	// and a password changed meanwhile are reported as conflict errors.
+	UpdateUser(conf *config.Config, user *model.User, passwordHash string) error
+	// UpdateUserProfile writes the profile fields of user, LastActive
+	// included.
+	UpdateUserProfile(conf *config.Config, user *model.User) error
+	TouchUser(conf *config.Config, id int64, lastActive time.Time) error
+	// SetUserLocation records the location of user id at now, unless it
+	// was recorded after notBefore, and reports whether it did.
+	SetUserLocation(conf *config.Config, id int64, latitude float64, longitude float64, now time.Time, notBefore time.Time) (bool, error)
+	// DeleteUser also deletes every relation of the user, on both sides.
+	DeleteUser(conf *config.Config, id int64) (bool, error)
+	GetUsers(conf *config.Config, page model.Page) ([]model.User, error)
+	// GetCandidates returns the users userId has neither swiped nor blocked,
+	// nor been blocked by, excluding userId. likers selects the users who
+	// like userId, otherwise the ones who do not. A non nil near keeps the
+	// users located within it.
+	GetCandidates(conf *config.Config, userId int64, likers bool, near *model.Circle, page model.Page) ([]model.User, error)
+}
+
+// RelationStore is the persistence contract used by the relation service.
+type RelationStore interface {
+	AddOrUpdateRelation(conf *config.Config, relation *model.Relation) (bool, error)
+	GetRelationByUserIdPairs(conf *config.Config, userId int64, otherUserId int64) (*model.Relation, error)
+	UpdateRelation(conf *config.Config, relation *model.Relation) error
+	DeleteRelation(conf *config.Config, userId int64, otherUserId int64) (bool, error)
+	GetRelationsByUserId(conf *config.Config, userId int64, filter model.RelationFilter, page model.Page) ([]model.Relation, error)
+	// RunInTransaction runs fn with a store whose calls are applied
+	// atomically; fn's error rolls everything back.
+	RunInTransaction(conf *config.Config, fn func(RelationStore) error) error
+	// LockUserPair serializes transactions touching the relations between
+	// two users, in either direction.
+	LockUserPair(conf *config.Config, userId int64, otherUserId int64) error
+	// GetDailyLikes returns the number of likes counted for userId on the
+	// UTC day starting at day.
+	GetDailyLikes(conf *config.Config, userId int64, day time.Time) (int, error)
+	// AddDailyLike counts one more like for userId on day unless limit likes
+	// are counted already, and returns the new count and whether it was
+	// counted.
+	AddDailyLike(conf *config.Config, userId int64, day time.Time, limit int) (int, bool, error)
+	// AddBlock records that userId blocks blockedUserId and reports whether
+	// the block is new.
+	AddBlock(conf *config.Config, userId int64, blockedUserId int64) (bool, error)
+	DeleteBlock(conf *config.Config, userId int64, blockedUserId int64) (bool, error)
+	// OpenConversation opens the conversation of a new match between the
+	// two users, unless one is open already, and returns its id.
+	OpenConversation(conf *config.Config, userId int64, otherUserId int64, now time.Time) (int64, error)
+	// CloseConversation closes the open conversation between the two users,
+	// if there is one, and returns its id or 0.
+	CloseConversation(conf *config.Config, userId int64, otherUserId int64, now time.Time) (int64, error)
+	// NotifyEvents sends events to the listeners of every server instance
+	// once the transaction commits, they are lost on rollback.
+	NotifyEvents(conf *config.Config, events []model.Event) error
+}
+
+var (
+	_ UserStore     = (*UserDao)(nil)
+	_ RelationStore = (*RelationDao)(nil)
+	_ UserStore     = (*MemoryUserDao)(nil)
+	_ RelationStore = (*MemoryRelationDao)(nil)
+
+	_ ConversationStore = (*ConversationDao)(nil)
+	_ ConversationStore = (*MemoryConversationDao)(nil)
+
+	_ EventStore = (*EventDao)(nil)
+	_ EventStore = (*MemoryEventDao)(nil)
+
+	_ WebhookStore = (*WebhookDao)(nil)
+	_ WebhookStore = (*MemoryWebhookDao)(nil)
+)
+
+// PhotoStore keeps the photos of the users, ordered by position.
+type PhotoStore interface {
+	// AddPhoto appends photo after the photos of its user, filling in its id
+	// and position. It reports false, adding nothing, when the user already
+	// has maxPhotos photos.
+	AddPhoto(conf *config.Config, photo *model.Photo, maxPhotos int) (bool, error)
+	GetPhotos(conf *config.Config, userId int64) ([]model.Photo, error)
+	// GetPhotosByUserIds returns the photos of several users at once.
+	GetPhotosByUserIds(conf *config.Config, userIds []int64) ([]model.Photo, error)
+	// DeletePhoto removes the photo of userId, moving up the photos after
+	// it, and returns it.
+	DeletePhoto(conf *config.Config, userId int64, photoId int64) (*model.Photo, error)
+	// ReorderPhotos gives the photos of userId the positions of their ids in
+	// photoIds, which must list every photo of the user exactly once.
+	ReorderPhotos(conf *config.Config, userId int64, photoIds []int64) error
+}
+
+// ConversationStore keeps the conversations of matches and their messages.
+// Conversations are opened and closed by the relation store, along with the
+// match.
+type ConversationStore interface {
+	GetConversation(conf *config.Config, id int64) (*model.Conversation, error)
+	// GetOpenConversations returns the open conversations of userId.
+	GetOpenConversations(conf *config.Config, userId int64, page model.Page) ([]model.Conversation, error)
+	// CountUnread returns, by conversation id, the number of messages sent
+	// to userId after the last one it read, conversations without unread
+	// messages are left out.
+	CountUnread(conf *config.Config, userId int64, conversationIds []int64) (map[int64]int, error)
+	// AddMessage stores message, filling in its id, and notifies events
+	// along, like RelationStore.NotifyEvents; events may point to message.
+	// It reports false, storing nothing, when the conversation is closed.
+	AddMessage(conf *config.Config, message *model.Message, events []model.Event) (bool, error)
+	// GetMessages returns the messages of a conversation from the newest
+	// one, page.AfterId is the id of the message the page continues from.
+	GetMessages(conf *config.Config, conversationId int64, page model.Page) ([]model.Message, error)
+	// MarkRead records that userId read the messages of a conversation up
+	// to messageId, and reports false when the conversation has no such
+	// message. Read receipts never move back.
+	MarkRead(conf *config.Config, conversationId int64, userId int64, messageId int64) (bool, error)
+}
+
+// WebhookStore keeps the webhooks and the outbox of their deliveries. The
+// deliveries of an event are queued along with the write it describes, in
+// its transaction, by UserStore.AddUser, RelationStore.AddOrUpdateRelation,
+// OpenConversation and CloseConversation, for the webhooks subscribed to
+// its type at that time.
+type WebhookStore interface {
+	AddWebhook(conf *config.Config, webhook *model.Webhook) error
+	GetWebhooks(conf *config.Config) ([]model.Webhook, error)
+	// DeleteWebhook also deletes its deliveries.
+	DeleteWebhook(conf *config.Config, id int64) (bool, error)
+	// ClaimDeliveries returns up to limit pending deliveries due at now and
+	// postpones them by lease, so that no other worker attempts them
+	// meanwhile.
+	ClaimDeliveries(conf *config.Config, now time.Time, lease time.Duration, limit int) ([]model.WebhookDelivery, error)
+	// UpdateDelivery records the outcome of an attempt of delivery.
+	UpdateDelivery(conf *config.Config, delivery *model.WebhookDelivery) error
+	GetDelivery(conf *config.Config, id int64) (*model.WebhookDelivery, error)
+	// GetDeliveries returns the deliveries with status, any status when it
+	// is empty, from the newest one; page.AfterId is the id of the delivery
+	// the page continues from.
+	GetDeliveries(conf *config.Config, status model.DeliveryStatus, page model.Page) ([]model.WebhookDelivery, error)
+	// ReplayDelivery makes a failed delivery pending again, due at now with
+	// no attempt, and reports false when delivery id is not failed.
+	ReplayDelivery(conf *config.Config, id int64, now time.Time) (bool, error)
+}
+
+// EventSink receives the events of the users, event.Hub is one.
+type EventSink interface {
+	Publish(e model.Event)
+	// Interrupt tells that events may have been lost.
+	Interrupt()
+}
+
+// EventStore carries the events notified by RelationStore.NotifyEvents and
+// ConversationStore.AddMessage to every server instance sharing the
+// storage.
+type EventStore interface {
+	// Listen delivers the events notified by any instance to sink, in commit
+	// order, until Close.
+	Listen(conf *config.Config, sink EventSink)
+	Close() error
+}
+
+// Stores groups the stores of one storage backend.
+type Stores struct {
+	Users         UserStore
+	Relations     RelationStore
+	Photos        PhotoStore
+	Conversations ConversationStore
+	Events        EventStore
+	Webhooks      WebhookStore
+}
+
+// NewStores returns the stores for the storage backend selected by
+// conf.Storage.
+func NewStores(conf *config.Config) (*Stores, error) {
+	switch conf.Storage {
+	case StoragePostgres, "":
+		registerPoolMetrics(conf)
+		return &Stores{Users: &UserDao{}, Relations: &RelationDao{}, Photos: &PhotoDao{}, Conversations: &ConversationDao{},
+			Events: &EventDao{}, Webhooks: &WebhookDao{}}, nil
+	case StorageMemory:
+		m := NewMemoryStorage()
+		return &Stores{Users: &MemoryUserDao{m: m}, Relations: &MemoryRelationDao{m: m}, Photos: &MemoryPhotoDao{m: m},
+			Conversations: &MemoryConversationDao{m: m}, Events: &MemoryEventDao{m: m}, Webhooks: &MemoryWebhookDao{m: m}}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q, expected %s or %s", conf.Storage, StorageMemory, StoragePostgres)
+	}
+}
@@ -3,55 +3,181 @@ package dao
 import (
 	"github.com/tangyang/simple-http-server/config"
 	"github.com/tangyang/simple-http-server/model"
+	pg "gopkg.in/pg.v4"
 
-	"fmt"
+	"time"
 )
 
 type UserDao struct {
 }
 
-func (u *UserDao) CreateUserSchema(conf *config.Config) error {
+// AddUser inserts user unless the name is taken, in which case user is
+// filled with the stored row and false is returned. A new user is queued for
+// the webhooks in the same transaction.
+func (u *UserDao) AddUser(conf *config.Config, user *model.User) (bool, error) {
+	defer observeQuery(conf, "UserDao.AddUser", time.Now())
 	c := NewPostgreConnector(conf)
-	_, err := c.DB.Exec("CREATE TABLE users (id bigserial PRIMARY key , name CHARACTER VARYING)")
-	return err
+	created := false
+	err := c.DB.RunInTransaction(func(tx *pg.Tx) error {
+		res, err := tx.Model(user).OnConflict("(name) DO NOTHING").Create()
+		if err != nil {
+			return err
+		}
+		if created = res.Affected() == 1; !created {
+			return tx.Model(user).Where("name=?", user.Name).Select()
+		}
+		return queueWebhookEvent(tx, model.WebhookUserCreated, userWebhookData(user), time.Now())
+	})
+	return created, wrapError(err, "Fail to add user %s", user.Name)
 }
 
-func (u *UserDao) AddUser(conf *config.Config, user *model.User) (bool, error) {
+func (u *UserDao) GetUserByName(conf *config.Config, name string) (*model.User, error) {
+	defer observeQuery(conf, "UserDao.GetUserByName", time.Now())
 	c := NewPostgreConnector(conf)
-	b, err := c.DB.Model(user).Where("name=?", user.Name).SelectOrCreate()
-	// err := c.DB.Create(user)
-	return b, err
+	user := &model.User{}
+	err := c.DB.Model(user).Where("name=?", name).Select()
+	if err != nil {
+		return nil, wrapError(err, "Fail to get user by name %s", name)
+	}
+	return user, nil
 }
 
-func (u *UserDao) GetUserByName(conf *config.Config, name string) *model.User {
+func (u *UserDao) GetUserById(conf *config.Config, id int64) (*model.User, error) {
+	defer observeQuery(conf, "UserDao.GetUserById", time.Now())
 	c := NewPostgreConnector(conf)
 	user := &model.User{}
-	err := c.DB.Model(user).Where("name=?", name).Select()
+	err := c.DB.Model(user).Where("id=?", id).Select()
+	if err != nil {
+		return nil, wrapError(err, "Fail to get user by id %d", id)
+	}
+	return user, nil
+}
+
+func (u *UserDao) UpdateUser(conf *config.Config, user *model.User, passwordHash string) error {
+	defer observeQuery(conf, "UserDao.UpdateUser", time.Now())
+	c := NewPostgreConnector(conf)
+	res, err := c.DB.Exec(`UPDATE users SET name = ?, password_hash = ?, premium = ? WHERE id = ? AND password_hash = ?`,
+		user.Name, user.PasswordHash, user.Premium, user.Id, passwordHash)
 	if err != nil {
-		fmt.Sprintf("Fail to get user by name %s, error: %s\n", name, err.Error())
+		if errorKind(err) == model.ErrorConflict {
+			return model.NewConflictError("Name already exists! ")
+		}
+		return wrapError(err, "Fail to update user %d", user.Id)
+	}
+	if res.Affected() > 0 {
 		return nil
 	}
-	return user
+	if _, err := u.GetUserById(conf, user.Id); err != nil {
+		return err
+	}
+	return model.NewConflictError("Password of user %d changed meanwhile, try again! ", user.Id)
 }
 
-// func (u *UserDao) GetUserById(conf *config.Config, id int64) *model.User {
-// 	c := NewPostgreConnector(conf)
-// 	user := &model.User{}
-// 	err := c.DB.Model(user).Where("id=?", id).Select()
-// 	if err != nil {
-// 		fmt.Sprintf("Fail to get user by id %d, error: %s\n", id, err.Error())
-// 		return nil
-// 	}
-// 	return user
-// }
+func (u *UserDao) UpdateUserProfile(conf *config.Config, user *model.User) error {
+	defer observeQuery(conf, "UserDao.UpdateUserProfile", time.Now())
+	c := NewPostgreConnector(conf)
+	res, err := c.DB.Model(user).Column("birth_date", "gender", "interested_in", "bio", "last_active").Where("id = ?", user.Id).Update()
+	if err != nil {
+		return wrapError(err, "Fail to update profile of user %d", user.Id)
+	}
+	if res.Affected() == 0 {
+		return model.NewNotFoundError("User %d does not exist", user.Id)
+	}
+	return nil
+}
 
-func (u *UserDao) GetAllUsers(conf *config.Config) []model.User {
+// TouchUser sets the last active time of user id.
+func (u *UserDao) TouchUser(conf *config.Config, id int64, lastActive time.Time) error {
+	defer observeQuery(conf, "UserDao.TouchUser", time.Now())
 	c := NewPostgreConnector(conf)
-	var users []model.User
-	_, err := c.DB.Query(&users, `SELECT * FROM users`)
+	_, err := c.DB.Exec(`UPDATE users SET last_active = ? WHERE id = ?`, lastActive, id)
+	return wrapError(err, "Fail to touch user %d", id)
+}
+
+func (u *UserDao) SetUserLocation(conf *config.Config, id int64, latitude float64, longitude float64, now time.Time, notBefore time.Time) (bool, error) {
+	defer observeQuery(conf, "UserDao.SetUserLocation", time.Now())
+	c := NewPostgreConnector(conf)
+	res, err := c.DB.Exec(`UPDATE users SET latitude = ?, longitude = ?, located_at = ? WHERE id = ? AND (located_at IS NULL OR located_at <= ?)`,
+		latitude, longitude, now, id, notBefore)
 	if err != nil {
-		fmt.Sprintf("Fail to get all users, error: %s\n", err.Error())
+		return false, wrapError(err, "Fail to set location of user %d", id)
+	}
+	if res.Affected() > 0 {
+		return true, nil
+	}
+	if _, err := u.GetUserById(conf, id); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// DeleteUser deletes the user together with every relation it takes part
+// in, on both sides, and reports whether the user existed.
+func (u *UserDao) DeleteUser(conf *config.Config, id int64) (bool, error) {
+	defer observeQuery(conf, "UserDao.DeleteUser", time.Now())
+	c := NewPostgreConnector(conf)
+	var deleted bool
+	err := c.DB.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM relations WHERE userid = ? OR otheruserid = ?`, id, id); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		deleted = res.Affected() > 0
 		return nil
+	})
+	return deleted, wrapError(err, "Fail to delete user %d", id)
+}
+
+// GetCandidates relies on anti-joins so that the users already swiped or
+// blocked are skipped by the indexes on relations and blocks instead of
+// being loaded. Distances are only computed for the users in the bounding
+// box of near, which the index on the coordinates finds.
+func (u *UserDao) GetCandidates(conf *config.Config, userId int64, likers bool, near *model.Circle, page model.Page) ([]model.User, error) {
+	defer observeQuery(conf, "UserDao.GetCandidates", time.Now())
+	c := NewPostgreConnector(conf)
+	likes := "NOT EXISTS"
+	if likers {
+		likes = "EXISTS"
+	}
+	query := `SELECT u.* FROM users u
+		WHERE u.id > ? AND u.id <> ?
+		AND ` + likes + ` (SELECT 1 FROM relations l WHERE l.userid = u.id AND l.otheruserid = ? AND l.status = ?)
+		AND NOT EXISTS (SELECT 1 FROM relations s WHERE s.userid = ? AND s.otheruserid = u.id)
+		AND NOT EXISTS (SELECT 1 FROM blocks b WHERE b.userid = ? AND b.blockeduserid = u.id)
+		AND NOT EXISTS (SELECT 1 FROM blocks b WHERE b.userid = u.id AND b.blockeduserid = ?)`
+	params := []interface{}{page.AfterId, userId, userId, model.RelationLike, userId, userId, userId}
+	if near != nil {
+		minLat, maxLat, minLon, maxLon, wraps := near.BoundingBox()
+		query += ` AND u.latitude BETWEEN ? AND ?`
+		params = append(params, minLat, maxLat)
+		if !wraps {
+			query += ` AND u.longitude BETWEEN ? AND ?`
+			params = append(params, minLon, maxLon)
+		}
+		query += ` AND 2 * ? * asin(sqrt(least(1, power(sin(radians(u.latitude - ?) / 2), 2)
+			+ cos(radians(?)) * cos(radians(u.latitude)) * power(sin(radians(u.longitude - ?) / 2), 2)))) <= ?`
+		params = append(params, model.EarthRadiusKm, near.Latitude, near.Latitude, near.Longitude, near.RadiusKm)
+	}
+	query += ` ORDER BY u.id LIMIT ?`
+	params = append(params, page.Limit)
+	users := []model.User{}
+	_, err := c.DB.Query(&users, query, params...)
+	if err != nil {
+		return nil, wrapError(err, "Fail to get candidates of user %d", userId)
+	}
+	return users, nil
+}
+
+func (u *UserDao) GetUsers(conf *config.Config, page model.Page) ([]model.User, error) {
+	defer observeQuery(conf, "UserDao.GetUsers", time.Now())
+	c := NewPostgreConnector(conf)
+	users := []model.User{}
+	_, err := c.DB.Query(&users, `SELECT * FROM users WHERE id > ? ORDER BY id LIMIT ?`, page.AfterId, page.Limit)
+	if err != nil {
+		return nil, wrapError(err, "Fail to get users")
 	}
-	return users
+	return users, nil
 }
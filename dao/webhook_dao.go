@@ -0,0 +1,133 @@
+package dao
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+
+	"encoding/json"
+	"time"
+)
+
+type WebhookDao struct {
+}
+
+// webhookPayload encodes the body posted to the webhooks for an event.
+func webhookPayload(eventType model.WebhookEventType, data interface{}, now time.Time) (string, error) {
+	b, err := json.Marshal(&model.WebhookPayload{Type: eventType, CreatedAt: now.UTC(), Data: data})
+	return string(b), err
+}
+
+// queueWebhookEvent writes on db one pending delivery of the event for every
+// webhook subscribed to eventType.
+func queueWebhookEvent(db dber, eventType model.WebhookEventType, data interface{}, now time.Time) error {
+	payload, err := webhookPayload(eventType, data, now)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO webhook_deliveries (webhookid, event_type, payload, status, next_attempt_at, created_at)
+		SELECT id, ?, ?, ?, ?, ? FROM webhooks WHERE ? = ANY (event_types)`,
+		eventType, payload, model.DeliveryPending, now, now, eventType)
+	return wrapError(err, "Fail to queue %s webhook deliveries", eventType)
+}
+
+func (d *WebhookDao) AddWebhook(conf *config.Config, webhook *model.Webhook) error {
+	defer observeQuery(conf, "WebhookDao.AddWebhook", time.Now())
+	c := NewPostgreConnector(conf)
+	_, err := c.DB.Model(webhook).Create()
+	return wrapError(err, "Fail to add webhook %s", webhook.Url)
+}
+
+func (d *WebhookDao) GetWebhooks(conf *config.Config) ([]model.Webhook, error) {
+	defer observeQuery(conf, "WebhookDao.GetWebhooks", time.Now())
+	c := NewPostgreConnector(conf)
+	webhooks := []model.Webhook{}
+	err := c.DB.Model(&webhooks).Order("id").Select()
+	return webhooks, wrapError(err, "Fail to get webhooks")
+}
+
+func (d *WebhookDao) DeleteWebhook(conf *config.Config, id int64) (bool, error) {
+	defer observeQuery(conf, "WebhookDao.DeleteWebhook", time.Now())
+	c := NewPostgreConnector(conf)
+	res, err := c.DB.Exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return false, wrapError(err, "Fail to delete webhook %d", id)
+	}
+	return res.Affected() > 0, nil
+}
+
+// ClaimDeliveries skips the rows locked by the other workers claiming at the
+// same time.
+func (d *WebhookDao) ClaimDeliveries(conf *config.Config, now time.Time, lease time.Duration, limit int) ([]model.WebhookDelivery, error) {
+	defer observeQuery(conf, "WebhookDao.ClaimDeliveries", time.Now())
+	c := NewPostgreConnector(conf)
+	deliveries := []model.WebhookDelivery{}
+	_, err := c.DB.Query(&deliveries, `UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id IN (
+		SELECT id FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at LIMIT ? FOR UPDATE SKIP LOCKED)
+		RETURNING *`, now.Add(lease), model.DeliveryPending, now, limit)
+	return deliveries, wrapError(err, "Fail to claim webhook deliveries")
+}
+
+func (d *WebhookDao) UpdateDelivery(conf *config.Config, delivery *model.WebhookDelivery) error {
+	defer observeQuery(conf, "WebhookDao.UpdateDelivery", time.Now())
+	c := NewPostgreConnector(conf)
+	var deliveredAt interface{}
+	if !delivery.DeliveredAt.IsZero() {
+		deliveredAt = delivery.DeliveredAt
+	}
+	_, err := c.DB.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = ?, last_status = ?, last_error = ?,
+		delivered_at = ? WHERE id = ?`, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastStatus, delivery.LastError,
+		deliveredAt, delivery.Id)
+	return wrapError(err, "Fail to update webhook delivery %d", delivery.Id)
+}
+
+func (d *WebhookDao) GetDelivery(conf *config.Config, id int64) (*model.WebhookDelivery, error) {
+	defer observeQuery(conf, "WebhookDao.GetDelivery", time.Now())
+	c := NewPostgreConnector(conf)
+	delivery := &model.WebhookDelivery{}
+	err := c.DB.Model(delivery).Where("id = ?", id).Select()
+	if err != nil {
+		return nil, wrapError(err, "Fail to get webhook delivery %d", id)
+	}
+	return delivery, nil
+}
+
+func (d *WebhookDao) GetDeliveries(conf *config.Config, status model.DeliveryStatus, page model.Page) ([]model.WebhookDelivery, error) {
+	defer observeQuery(conf, "WebhookDao.GetDeliveries", time.Now())
+	c := NewPostgreConnector(conf)
+	deliveries := []model.WebhookDelivery{}
+	q := c.DB.Model(&deliveries)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if page.AfterId > 0 {
+		q = q.Where("id < ?", page.AfterId)
+	}
+	err := q.Order("id DESC").Limit(page.Limit).Select()
+	return deliveries, wrapError(err, "Fail to get webhook deliveries")
+}
+
+func (d *WebhookDao) ReplayDelivery(conf *config.Config, id int64, now time.Time) (bool, error) {
+	defer observeQuery(conf, "WebhookDao.ReplayDelivery", time.Now())
+	c := NewPostgreConnector(conf)
+	res, err := c.DB.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status = ?`,
+		model.DeliveryPending, now, id, model.DeliveryFailed)
+	if err != nil {
+		return false, wrapError(err, "Fail to replay webhook delivery %d", id)
+	}
+	return res.Affected() > 0, nil
+}
+
+// userWebhookData and the helpers below build the data of the webhook
+// events queued by the stores.
+func userWebhookData(user *model.User) *model.WebhookUserData {
+	return &model.WebhookUserData{UserId: user.Id, Name: user.Name}
+}
+
+func swipeWebhookData(relation *model.Relation) *model.WebhookSwipeData {
+	return &model.WebhookSwipeData{UserId: relation.Userid, OtherUserId: relation.Otheruserid,
+		State: relation.Status.ToRelationStatusDescription()}
+}
+
+func matchWebhookData(matchId int64, userId int64, otherUserId int64) *model.WebhookMatchData {
+	return &model.WebhookMatchData{MatchId: matchId, UserId: userId, OtherUserId: otherUserId}
+}
@@ -0,0 +1,211 @@
+// Package event delivers the events of the users to the connections they
+// keep open to this server.
+package event
+
+import (
+	"github.com/tangyang/simple-http-server/model"
+	"sync"
+	"time"
+)
+
+// replayTtl is how long the recent events of a user are kept after its last
+// event.
+const replayTtl = 10 * time.Minute
+
+// Hub routes published events to the subscriptions of their user and keeps
+// the recent events of every user, so that a client can resume after a
+// disconnection. Publish never blocks: a subscription whose buffer is full
+// is dropped, its reader is too slow to keep up.
+type Hub struct {
+	mu            sync.Mutex
+	subscriptions map[int64]map[*Subscription]bool
+	replays       map[int64]*replay
+	replaySize    int
+	lastSweep     time.Time
+}
+
+// replay holds the last events of a user, oldest first. Events with an id
+// up to floor may be missing from it: they were evicted, or published
+// before it was created.
+type replay struct {
+	events    []model.Event
+	floor     int64
+	lastId    int64
+	updatedAt time.Time
+}
+
+// NewHub returns a hub keeping the last replaySize events of every user.
+func NewHub(replaySize int) *Hub {
+	return &Hub{
+		subscriptions: make(map[int64]map[*Subscription]bool),
+		replays:       make(map[int64]*replay),
+		replaySize:    replaySize,
+		lastSweep:     time.Now(),
+	}
+}
+
+// Subscription receives the events of one user, until it is closed or
+// dropped.
+type Subscription struct {
+	UserId  int64
+	hub     *Hub
+	events  chan model.Event
+	dropped chan struct{}
+	once    sync.Once
+}
+
+// Subscribe returns a subscription to the events of userId which buffers up
+// to buffer events.
+func (h *Hub) Subscribe(userId int64, buffer int) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.subscribe(userId, buffer)
+}
+
+// Resume subscribes to the events of userId like Subscribe, and returns the
+// events published after the event lastId. It reports false when some of
+// them may be missing, the client should then reload its state.
+func (h *Hub) Resume(userId int64, buffer int, lastId int64) (*Subscription, []model.Event, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := h.replay(userId, time.Now().UnixMicro())
+	var events []model.Event
+	for _, e := range r.events {
+		if e.Id > lastId {
+			events = append(events, e)
+		}
+	}
+	return h.subscribe(userId, buffer), events, lastId >= r.floor
+}
+
+// subscribe registers a subscription, the caller holds the lock.
+func (h *Hub) subscribe(userId int64, buffer int) *Subscription {
+	s := &Subscription{UserId: userId, hub: h, events: make(chan model.Event, buffer), dropped: make(chan struct{})}
+	if h.subscriptions[userId] == nil {
+		h.subscriptions[userId] = make(map[*Subscription]bool)
+	}
+	h.subscriptions[userId][s] = true
+	return s
+}
+
+// Publish gives e an id, keeps it for replay and hands it to every
+// subscription of e.UserId.
+func (h *Hub) Publish(e model.Event) {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sweep(now)
+	// Ids follow the creation time of the events so that every server
+	// instance given the same events gives them the same ids, and ids stay
+	// meaningful across restarts.
+	e.Id = e.CreatedAt.UnixMicro()
+	r := h.replay(e.UserId, e.Id-1)
+	if e.Id <= r.lastId {
+		e.Id = r.lastId + 1
+	}
+	r.lastId, r.updatedAt = e.Id, now
+	if h.replaySize > 0 {
+		if len(r.events) == h.replaySize {
+			r.floor = r.events[0].Id
+			r.events = append(r.events[:0], r.events[1:]...)
+		}
+		r.events = append(r.events, e)
+	} else {
+		r.floor = e.Id
+	}
+	for s := range h.subscriptions[e.UserId] {
+		h.deliver(s, e)
+	}
+}
+
+// deliver hands e to s, or drops s when its buffer is full. The caller
+// holds the lock.
+func (h *Hub) deliver(s *Subscription, e model.Event) {
+	select {
+	case s.events <- e:
+	default:
+		h.remove(s)
+		s.once.Do(func() { close(s.dropped) })
+	}
+}
+
+// replay returns the replay of userId, created with floor when missing. The
+// caller holds the lock.
+func (h *Hub) replay(userId int64, floor int64) *replay {
+	r, ok := h.replays[userId]
+	if !ok {
+		r = &replay{floor: floor, updatedAt: time.Now()}
+		h.replays[userId] = r
+	}
+	return r
+}
+
+// Interrupt tells that events may have been lost on their way to the hub.
+// The subscriptions get a resync event, and the clients resuming from an
+// earlier event are told to reload.
+func (h *Hub) Interrupt() {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.replays {
+		if r.floor < now.UnixMicro() {
+			r.floor = now.UnixMicro()
+		}
+	}
+	for userId, subscriptions := range h.subscriptions {
+		for s := range subscriptions {
+			h.deliver(s, model.Event{Type: model.EventResync, UserId: userId, CreatedAt: now})
+		}
+	}
+}
+
+// sweep forgets the replays of the users without recent events nor
+// subscriptions, at most once a minute. The caller holds the lock.
+func (h *Hub) sweep(now time.Time) {
+	if now.Sub(h.lastSweep) < time.Minute {
+		return
+	}
+	h.lastSweep = now
+	for userId, r := range h.replays {
+		if now.Sub(r.updatedAt) > replayTtl && len(h.subscriptions[userId]) == 0 {
+			delete(h.replays, userId)
+		}
+	}
+}
+
+// Subscribers returns the number of open subscriptions.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, subscriptions := range h.subscriptions {
+		n += len(subscriptions)
+	}
+	return n
+}
+
+// remove unregisters s, the caller holds the lock.
+func (h *Hub) remove(s *Subscription) {
+	delete(h.subscriptions[s.UserId], s)
+	if len(h.subscriptions[s.UserId]) == 0 {
+		delete(h.subscriptions, s.UserId)
+	}
+}
+
+// Events returns the channel the events are delivered on.
+func (s *Subscription) Events() <-chan model.Event {
+	return s.events
+}
+
+// Dropped is closed once the subscription was dropped because its buffer
+// was full, no event is delivered after that.
+func (s *Subscription) Dropped() <-chan struct{} {
+	return s.dropped
+}
+
+// Close stops the delivery of events to s.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"github.com/gorilla/mux"
 	"github.com/tangyang/simple-http-server/config"
 	"github.com/tangyang/simple-http-server/controller"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type dispatcher struct {
@@ -17,16 +19,43 @@ func (d *dispatcher) SetHandler(handler http.Handler) {
 	d.handler = handler
 }
 
-func initHttpServer(conf *config.Config) {
+func initHttpServer(conf *config.Config) (*http.Server, error) {
 
 	r := mux.NewRouter()
-	controller.InitRouters(r, conf)
+	if err := controller.InitRouters(r, conf); err != nil {
+		return nil, err
+	}
+	port := strings.Join([]string{"0.0.0.0", conf.HttpPort}, ":")
+	server := &http.Server{Addr: port, Handler: r}
 	go func() {
-		port := strings.Join([]string{"0.0.0.0", conf.HttpPort}, ":")
-		err := http.ListenAndServe(port, r)
-		if err != nil {
-			fmt.Println(err)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("http server failed", "error", err.Error())
 		}
 	}()
-	fmt.Println("Http server is initialized... ")
+	slog.Info("http server is initialized", "addr", port)
+	return server, nil
+}
+
+// shutdownHttpServer reports the server as not ready, keeps serving for
+// conf.ShutdownDelay seconds so that load balancers notice, then closes the
+// real-time connections, stops accepting connections and waits up to
+// conf.ShutdownTimeout seconds for in-flight requests to finish.
+func shutdownHttpServer(conf *config.Config, server *http.Server) error {
+	controller.SetReady(false)
+	if conf.ShutdownDelay > 0 {
+		slog.Info("http server is not ready anymore, waiting before shutdown", "delay_seconds", conf.ShutdownDelay)
+		time.Sleep(time.Duration(conf.ShutdownDelay) * time.Second)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(conf.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := controller.CloseStreams(ctx); err != nil {
+		slog.Warn("real-time connections still open at shutdown", "error", err.Error())
+	}
+	err := server.Shutdown(ctx)
+	if err == nil {
+		slog.Info("http server is stopped")
+	}
+	return err
 }
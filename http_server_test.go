@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/controller"
+
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	defer controller.SetReady(true)
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		io.WriteString(w, "done")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(ln)
+
+	type response struct {
+		status int
+		body   string
+		err    error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- response{status: resp.StatusCode, body: string(body), err: err}
+	}()
+	<-started
+
+	conf := &config.Config{ShutdownTimeout: 5}
+	start := time.Now()
+	if err := shutdownHttpServer(conf, server); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("shutdown returned after %v, before the request finished", elapsed)
+	}
+	if controller.IsReady() {
+		t.Error("server still reported ready after shutdown")
+	}
+
+	select {
+	case resp := <-responses:
+		if resp.err != nil {
+			t.Fatalf("in-flight request failed: %v", resp.err)
+		}
+		if resp.status != http.StatusOK || resp.body != "done" {
+			t.Errorf("got status %d body %q, want 200 \"done\"", resp.status, resp.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request did not complete")
+	}
+
+	if _, err := http.Get("http://" + ln.Addr().String() + "/slow"); err == nil {
+		t.Error("server still accepts connections after shutdown")
+	}
+}
+
+func TestShutdownTimesOut(t *testing.T) {
+	defer controller.SetReady(true)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(ln)
+	go http.Get("http://" + ln.Addr().String() + "/stuck")
+	<-started
+
+	conf := &config.Config{ShutdownTimeout: 1}
+	if err := shutdownHttpServer(conf, server); err == nil {
+		t.Error("shutdown succeeded with a request still in flight")
+	}
+}
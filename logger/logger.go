@@ -0,0 +1,59 @@
+// Package logger configures the process wide structured logger and carries
+// the request id of the request being served.
+//
+// Log lines are JSON objects written to stdout. Code serving a request logs
+// through FromContext so that every line carries the request id; other code
+// uses the log/slog package level functions.
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type contextKey int
+
+const requestIdKey contextKey = 0
+
+// Init installs a JSON logger writing lines at level or above, level is one
+// of debug, info, warn and error.
+func Init(level string) error {
+	var l slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		l = slog.LevelDebug
+	case "info", "":
+		l = slog.LevelInfo
+	case "warn", "warning":
+		l = slog.LevelWarn
+	case "error":
+		l = slog.LevelError
+	default:
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: l})))
+	return nil
+}
+
+// WithRequestId returns a copy of ctx carrying the request id.
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey, requestId)
+}
+
+// RequestId returns the request id carried by ctx, or "".
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// FromContext returns the default logger, annotated with the request id of
+// ctx when there is one.
+func FromContext(ctx context.Context) *slog.Logger {
+	if id := RequestId(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
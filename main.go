@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"github.com/tangyang/simple-http-server/config"
-	"github.com/tangyang/simple-http-server/dao"
+	"github.com/tangyang/simple-http-server/logger"
 	tpprof "github.com/tangyang/simple-http-server/pprof"
+	"github.com/tangyang/simple-http-server/service"
+	"log/slog"
 	"os"
 	"os/signal"
 	"runtime/pprof"
@@ -22,32 +24,74 @@ func main() {
 	conf := config.NewConfig()
 
 	if conf.InitDB {
-		userDao := &dao.UserDao{}
-		relationDao := &dao.RelationDao{}
-		err := userDao.CreateUserSchema(conf)
-		if err != nil {
-			fmt.Printf("Fail to create user schema, error: %s\n", err.Error())
-			return
+		fmt.Println("The -init flag is deprecated, use the \"migrate up\" command instead. ")
+		conf.Command = []string{"migrate", "up"}
+	}
+
+	if len(conf.Command) > 0 {
+		var err error
+		switch conf.Command[0] {
+		case "migrate":
+			err = runMigrate(conf, conf.Command[1:])
+		case "repair":
+			err = runRepair(conf, conf.Command[1:])
+		case "token":
+			err = runToken(conf, conf.Command[1:])
+		default:
+			fmt.Printf("Unknown command %s\n", conf.Command[0])
+			os.Exit(2)
 		}
-		err = relationDao.CreateRelationSchema(conf)
 		if err != nil {
-			fmt.Printf("Fail to create relation schema, error: %s\n", err.Error())
+			fmt.Printf("Fail to run %s, error: %s\n", conf.Command[0], err.Error())
+			os.Exit(1)
 		}
-		fmt.Println("Init database schema... ")
 		return
 	}
 
-	initHttpServer(conf)
+	if err := logger.Init(conf.LogLevel); err != nil {
+		fmt.Printf("Fail to init logger, error: %s\n", err.Error())
+		os.Exit(2)
+	}
+
+	if err := conf.Validate(); err != nil {
+		slog.Error("invalid config", "error", err.Error())
+		os.Exit(2)
+	}
+	if conf.AuthDisabled {
+		slog.Warn("authentication is disabled, any caller can act as any user")
+	}
+
+	err := service.InitStorage(conf)
+	if err != nil {
+		slog.Error("fail to init storage", "error", err.Error())
+		os.Exit(1)
+	}
+
+	service.StartWebhookWorker(conf)
+
+	server, err := initHttpServer(conf)
+	if err != nil {
+		slog.Error("fail to init http server", "error", err.Error())
+		os.Exit(2)
+	}
 
 	for {
 		s := <-signalChan
 
-		fmt.Println("Program exiting, get a signal: ", s)
 		if s == syscall.SIGQUIT {
 			p := pprof.Lookup("heap")
 			p.WriteTo(os.Stdout, 2)
-		} else {
-			os.Exit(0)
+			continue
 		}
+
+		slog.Info("program exiting", "signal", s.String())
+		if err := shutdownHttpServer(conf, server); err != nil {
+			slog.Error("fail to drain http connections", "error", err.Error())
+		}
+		service.StopWebhookWorker()
+		if err := service.CloseStorage(); err != nil {
+			slog.Error("fail to close storage", "error", err.Error())
+		}
+		return
 	}
 }
@@ -0,0 +1,268 @@
+// Package metrics implements the few Prometheus metric types the server needs
+// and renders them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector writes its samples, including the HELP and TYPE lines.
+type Collector interface {
+	Name() string
+	Collect(w io.Writer)
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]Collector{}
+)
+
+// Register adds c to the collectors exposed by Handler. Registering a second
+// collector with the same name replaces the first one.
+func Register(c Collector) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[c.Name()] = c
+}
+
+// WriteTo writes every registered collector, ordered by name.
+func WriteTo(w io.Writer) {
+	registryLock.RLock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	collectors := make([]Collector, 0, len(registry))
+	sort.Strings(names)
+	for _, name := range names {
+		collectors = append(collectors, registry[name])
+	}
+	registryLock.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.Collect(bw)
+	}
+	bw.Flush()
+}
+
+// Handler serves the registered metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteTo(w)
+	})
+}
+
+// DefBuckets are latency buckets in seconds.
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type series struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64
+	count       uint64
+}
+
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{name: name, help: help, labels: labels, series: map[string]*series{}}
+}
+
+func (v *vec) Name() string {
+	return v.name
+}
+
+// get returns the series of labelValues, v.mu must be held.
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted returns a snapshot of the series ordered by label values.
+func (v *vec) sorted() []series {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]series, 0, len(keys))
+	for _, key := range keys {
+		s := *v.series[key]
+		s.buckets = append([]uint64(nil), s.buckets...)
+		result = append(result, s)
+	}
+	return result
+}
+
+func (v *vec) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, typ)
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels)}
+	if len(labels) == 0 {
+		// a counter without labels is exposed as 0 before its first use
+		c.get(nil)
+	}
+	Register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	c.get(labelValues).value += delta
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) Collect(w io.Writer) {
+	c.header(w, "counter")
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labelValues, "", ""), formatValue(s.value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec
+	upperBounds []float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec(name, help, labels), upperBounds: buckets}
+	Register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.upperBounds))
+	}
+	for i, bound := range h.upperBounds {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.value += value
+}
+
+func (h *HistogramVec) Collect(w io.Writer) {
+	h.header(w, "histogram")
+	for _, s := range h.sorted() {
+		for i, bound := range h.upperBounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", formatValue(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), formatValue(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+// ValueFunc is a metric without labels whose value is read at scrape time,
+// typ is "counter" or "gauge".
+type ValueFunc struct {
+	name string
+	help string
+	typ  string
+	fn   func() float64
+}
+
+func NewGaugeFunc(name, help string, fn func() float64) *ValueFunc {
+	g := &ValueFunc{name: name, help: help, typ: "gauge", fn: fn}
+	Register(g)
+	return g
+}
+
+func NewCounterFunc(name, help string, fn func() float64) *ValueFunc {
+	c := &ValueFunc{name: name, help: help, typ: "counter", fn: fn}
+	Register(c)
+	return c
+}
+
+func (f *ValueFunc) Name() string {
+	return f.name
+}
+
+func (f *ValueFunc) Collect(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatValue(f.fn()))
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/dao"
+	"strconv"
+)
+
+const migrateUsage = "usage: simple-http-server migrate up | down N | status"
+
+// runMigrate executes the "migrate" sub command, args excludes the
+// "migrate" word itself.
+func runMigrate(conf *config.Config, args []string) error {
+	if conf.Storage == dao.StorageMemory {
+		return errors.New("migrations only apply to the postgres storage")
+	}
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	switch args[0] {
+	case "up":
+		done, err := dao.MigrateUp(conf)
+		for _, m := range done {
+			fmt.Printf("Applied migration %d: %s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("Database schema is up to date. ")
+		}
+		return err
+	case "down":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of migrations to revert: %s", args[1])
+		}
+		done, err := dao.MigrateDown(conf, n)
+		for _, m := range done {
+			fmt.Printf("Reverted migration %d: %s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := dao.GetMigrationStatus(conf)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			if s.Applied {
+				fmt.Printf("%4d  applied %s  %s\n", s.Version, s.AppliedAt.Format("2006-01-02 15:04:05"), s.Name)
+			} else {
+				fmt.Printf("%4d  pending                      %s\n", s.Version, s.Name)
+			}
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
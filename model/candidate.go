@@ -0,0 +1,19 @@
+package model
+
+// Candidate is a user suggested to a viewer, LikesViewer is set when the
+// user already likes the viewer.
+type Candidate struct {
+	User
+	LikesViewer bool
+	// DistanceKm is the distance to the viewer, nil when either of them has
+	// no location.
+	DistanceKm *float64
+}
+
+// CandidateCursor is a position in the candidates of a viewer. The users who
+// like the viewer come first, then the others, each group ordered by id;
+// Likers tells the group AfterId belongs to.
+type CandidateCursor struct {
+	Likers  bool
+	AfterId int64
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"time"
+)
+
+// Conversation is the chat of a match, its id is the match id. Userid is the
+// smaller of the two user ids. A conversation is open while the users are
+// matched and closed for good when the match is undone, matching again
+// opens a new one.
+type Conversation struct {
+	Id          int64
+	Userid      int64
+	Otheruserid int64
+	// UserLastReadId and OtherUserLastReadId are the ids of the last
+	// message read by Userid and by Otheruserid, 0 when none was read.
+	UserLastReadId      int64
+	OtherUserLastReadId int64
+	CreatedAt           time.Time
+	ClosedAt            time.Time `sql:",null"`
+	LastMessageAt       time.Time `sql:",null"`
+}
+
+func (c *Conversation) IsOpen() bool {
+	return c.ClosedAt.IsZero()
+}
+
+// HasMember reports whether userId is one of the two matched users.
+func (c *Conversation) HasMember(userId int64) bool {
+	return userId == c.Userid || userId == c.Otheruserid
+}
+
+// OtherMember returns the user matched with userId.
+func (c *Conversation) OtherMember(userId int64) int64 {
+	if userId == c.Userid {
+		return c.Otheruserid
+	}
+	return c.Userid
+}
+
+// LastReadBy returns the id of the last message read by userId.
+func (c *Conversation) LastReadBy(userId int64) int64 {
+	if userId == c.Userid {
+		return c.UserLastReadId
+	}
+	return c.OtherUserLastReadId
+}
+
+// ConversationPair orders the ids of two users the way conversations store
+// them.
+func ConversationPair(userId int64, otherUserId int64) (int64, int64) {
+	if userId > otherUserId {
+		return otherUserId, userId
+	}
+	return userId, otherUserId
+}
+
+// Match is an open conversation as seen by one of its users.
+type Match struct {
+	Conversation
+	// Unread is the number of messages sent to the user it did not read
+	// yet.
+	Unread int
+}
+
+// Message is a message sent by Senderid in a conversation.
+type Message struct {
+	Id             int64
+	Conversationid int64
+	Senderid       int64
+	Body           string
+	CreatedAt      time.Time
+}
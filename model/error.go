@@ -0,0 +1,138 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type ErrorKind int
+
+const (
+	ErrorInternal ErrorKind = iota
+	ErrorValidation
+	ErrorNotFound
+	ErrorConflict
+	ErrorStorageUnavailable
+	ErrorUnauthorized
+	ErrorForbidden
+	ErrorTooManyRequests
+)
+
+// Error is the error type shared by dao, service and controller. Kind decides
+// the status code the client gets, Message is safe to show to the client and
+// Err keeps the underlying cause for the server side.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+	// Fields is set on validation errors about several request fields.
+	Fields []FieldError
+}
+
+// FieldError tells why the value of one request field was refused.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err.Error())
+	}
+	return e.Message
+}
+
+func NewError(kind ErrorKind, cause error, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...), Err: cause}
+}
+
+func NewValidationError(format string, args ...interface{}) *Error {
+	return NewError(ErrorValidation, nil, format, args...)
+}
+
+// NewFieldValidationError returns a validation error listing the refused
+// fields.
+func NewFieldValidationError(fields []FieldError) *Error {
+	e := NewValidationError("Invalid parameters: %s", fieldNames(fields))
+	e.Fields = fields
+	return e
+}
+
+func fieldNames(fields []FieldError) string {
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		names = append(names, f.Field)
+	}
+	return strings.Join(names, ", ")
+}
+
+func NewNotFoundError(format string, args ...interface{}) *Error {
+	return NewError(ErrorNotFound, nil, format, args...)
+}
+
+func NewConflictError(format string, args ...interface{}) *Error {
+	return NewError(ErrorConflict, nil, format, args...)
+}
+
+func NewUnauthorizedError(format string, args ...interface{}) *Error {
+	return NewError(ErrorUnauthorized, nil, format, args...)
+}
+
+func NewForbiddenError(format string, args ...interface{}) *Error {
+	return NewError(ErrorForbidden, nil, format, args...)
+}
+
+func NewTooManyRequestsError(format string, args ...interface{}) *Error {
+	return NewError(ErrorTooManyRequests, nil, format, args...)
+}
+
+// ErrorKindOf returns the kind of err, errors not created by this package
+// are internal errors.
+func ErrorKindOf(err error) ErrorKind {
+	if e, ok := err.(*Error); ok {
+		return e.Kind
+	}
+	return ErrorInternal
+}
+
+func IsNotFound(err error) bool {
+	return err != nil && ErrorKindOf(err) == ErrorNotFound
+}
+
+// StatusCode maps the kind to an HTTP status code.
+func (k ErrorKind) StatusCode() int {
+	switch k {
+	case ErrorValidation:
+		return http.StatusBadRequest
+	case ErrorNotFound:
+		return http.StatusNotFound
+	case ErrorConflict:
+		return http.StatusConflict
+	case ErrorStorageUnavailable:
+		return http.StatusServiceUnavailable
+	case ErrorUnauthorized:
+		return http.StatusUnauthorized
+	case ErrorForbidden:
+		return http.StatusForbidden
+	case ErrorTooManyRequests:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// NewErrorResult builds the response envelope for err. Internal and storage
+// errors get a generic message so that no server detail leaks to clients.
+func NewErrorResult(err error) Result {
+	kind := ErrorKindOf(err)
+	switch kind {
+	case ErrorInternal:
+		return Result{Code: kind.StatusCode(), Message: "Something is wrong with server. "}
+	case ErrorStorageUnavailable:
+		return Result{Code: kind.StatusCode(), Message: "Storage is temporarily unavailable. "}
+	default:
+		e := err.(*Error)
+		return Result{Code: kind.StatusCode(), Message: e.Message, Errors: e.Fields}
+	}
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+)
+
+type EventType string
+
+const (
+	// EventLike tells a user that OtherUserId likes it.
+	EventLike    EventType = "like"
+	EventMatch   EventType = "match"
+	EventUnmatch EventType = "unmatch"
+	EventMessage EventType = "message"
+	// EventResync tells that events may have been missed, the client should
+	// reload its state. It has no id.
+	EventResync EventType = "resync"
+)
+
+// Event is a change pushed in real time to UserId. OtherUserId is the user
+// on the other side: the liker, the match or the sender of Message. Ids
+// increase over the events of a user.
+type Event struct {
+	Id          int64
+	Type        EventType
+	UserId      int64
+	OtherUserId int64
+	MatchId     int64
+	Message     *Message `json:",omitempty"`
+	CreatedAt   time.Time
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"math"
+)
+
+// EarthRadiusKm is the mean radius of the Earth used for distances.
+const EarthRadiusKm = 6371.0
+
+// Circle is the area within RadiusKm of a point.
+type Circle struct {
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+}
+
+// DistanceKm returns the great-circle distance between two points with the
+// haversine formula.
+func DistanceKm(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	dLat := radians(lat2 - lat1)
+	dLon := radians(lon2 - lon1)
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(radians(lat1))*math.Cos(radians(lat2))*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * EarthRadiusKm * math.Asin(math.Sqrt(math.Min(1, a)))
+}
+
+// BoundingBox returns the latitude and longitude ranges enclosing c, to
+// prefilter points before computing distances. wraps is set when the circle
+// reaches a pole or crosses the antimeridian, then every longitude may be in
+// the circle and only the latitude range applies.
+func (c Circle) BoundingBox() (minLat float64, maxLat float64, minLon float64, maxLon float64, wraps bool) {
+	dLat := degrees(c.RadiusKm / EarthRadiusKm)
+	minLat, maxLat = c.Latitude-dLat, c.Latitude+dLat
+	if minLat <= -90 || maxLat >= 90 {
+		return math.Max(minLat, -90), math.Min(maxLat, 90), -180, 180, true
+	}
+	dLon := degrees(math.Asin(math.Min(1, math.Sin(c.RadiusKm/EarthRadiusKm)/math.Cos(radians(c.Latitude)))))
+	minLon, maxLon = c.Longitude-dLon, c.Longitude+dLon
+	if minLon < -180 || maxLon > 180 {
+		return minLat, maxLat, -180, 180, true
+	}
+	return minLat, maxLat, minLon, maxLon, false
+}
+
+// distanceBuckets are the distances shown to users below 100 km, beyond that
+// they are rounded up to a multiple of 100 km. Coarse buckets keep a viewer
+// from locating a user from the distances seen from a few places.
+var distanceBuckets = []int{1, 2, 5, 10, 20, 50, 100}
+
+// RoundDistanceKm rounds km up to its distance bucket, at least the first
+// one. Distances beyond half the circumference of the Earth are rounded as
+// that.
+func RoundDistanceKm(km float64) int {
+	for _, bucket := range distanceBuckets {
+		if km <= float64(bucket) {
+			return bucket
+		}
+	}
+	return int(math.Ceil(math.Min(km, math.Pi*EarthRadiusKm)/100)) * 100
+}
+
+// Contains reports whether the point is in c.
+func (c Circle) Contains(latitude float64, longitude float64) bool {
+	return DistanceKm(c.Latitude, c.Longitude, latitude, longitude) <= c.RadiusKm
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func degrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
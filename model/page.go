@@ -0,0 +1,8 @@
+package model
+
+// Page selects a window of rows for keyset pagination: at most Limit rows
+// with an id greater than AfterId, ordered by id.
+type Page struct {
+	AfterId int64
+	Limit   int
+}
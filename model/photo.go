@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+)
+
+// Photo is a picture of a user. Photos of a user are ordered by Position
+// from 0, the photo at position 0 is the primary one. Key and ThumbnailKey
+// locate the image and its thumbnail in the blob storage.
+type Photo struct {
+	Id           int64
+	Userid       int64
+	Position     int
+	Key          string
+	ThumbnailKey string
+	ContentType  string
+	Width        int
+	Height       int
+	CreatedAt    time.Time
+}
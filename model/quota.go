@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+)
+
+// LikeQuota is the state of the daily like quota of a user: Limit likes a
+// day, Remaining of them left until ResetAt.
+type LikeQuota struct {
+	Limit     int
+	Remaining int
+	ResetAt   time.Time
+}
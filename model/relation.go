@@ -1,5 +1,9 @@
 package model
 
+import (
+	"strings"
+)
+
 type Relation struct {
 	Id          int64
 	Userid      int64
@@ -13,6 +17,9 @@ const (
 	RelationLike RelationStatus = iota
 	RelationDislike
 	RelationMatched
+	// RelationAnyStatus is only used in a RelationFilter to match every
+	// status.
+	RelationAnyStatus RelationStatus = -1
 )
 
 type RelationStatusDescription string
@@ -32,3 +39,29 @@ func (r RelationStatus) ToRelationStatusDescription() RelationStatusDescription
 		return RelationMatchedDescription
 	}
 }
+
+// ParseRelationStatus returns the status described by description, compared
+// case-insensitively.
+func ParseRelationStatus(description string) (RelationStatus, bool) {
+	for _, status := range []RelationStatus{RelationLike, RelationDislike, RelationMatched} {
+		if strings.EqualFold(description, string(status.ToRelationStatusDescription())) {
+			return status, true
+		}
+	}
+	return RelationAnyStatus, false
+}
+
+// RelationDirection tells whether a relation is seen from the user who swiped
+// (outgoing) or from the user who was swiped on (incoming).
+type RelationDirection string
+
+const (
+	RelationOutgoing RelationDirection = "outgoing"
+	RelationIncoming RelationDirection = "incoming"
+)
+
+// RelationFilter selects the relations of a user listed by the relation store.
+type RelationFilter struct {
+	Direction RelationDirection
+	Status    RelationStatus
+}
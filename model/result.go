@@ -4,4 +4,12 @@ type Result struct {
 	Code    int
 	Message string
 	Data    interface{}
+	// NextCursor is set on list responses that have more items, pass it
+	// back as the cursor query parameter to get the next page.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// RequestId is set on error responses, it matches the X-Request-ID
+	// response header and the request_id field of the server logs.
+	RequestId string `json:",omitempty"`
+	// Errors details validation errors field by field.
+	Errors []FieldError `json:",omitempty"`
 }
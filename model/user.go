@@ -1,6 +1,72 @@
 package model
 
+import (
+	"time"
+)
+
 type User struct {
 	Id   int64
 	Name string
+	// PasswordHash is empty for users who can not log in.
+	PasswordHash string
+	// Premium users have no daily like quota.
+	Premium bool
+
+	// The profile of the user, every field is optional. BirthDate only
+	// keeps the day, in UTC.
+	BirthDate    time.Time `sql:",null"`
+	Gender       string
+	InterestedIn []string `pg:",array"`
+	Bio          string
+	LastActive   time.Time `sql:",null"`
+
+	// Latitude and Longitude locate the user, they are nil until the user
+	// reports a location and are never shown to other users. LocatedAt is
+	// the time of the last report.
+	Latitude  *float64
+	Longitude *float64
+	LocatedAt time.Time `sql:",null"`
+}
+
+func (u *User) HasLocation() bool {
+	return u.Latitude != nil && u.Longitude != nil
+}
+
+const (
+	GenderFemale    = "female"
+	GenderMale      = "male"
+	GenderNonBinary = "nonbinary"
+)
+
+// IsGender reports whether s is one of the genders users can pick.
+func IsGender(s string) bool {
+	return s == GenderFemale || s == GenderMale || s == GenderNonBinary
+}
+
+// Age returns the age in whole years at now of someone born on birthDate.
+func Age(birthDate time.Time, now time.Time) int {
+	now = now.UTC()
+	age := now.Year() - birthDate.Year()
+	if now.Month() < birthDate.Month() || (now.Month() == birthDate.Month() && now.Day() < birthDate.Day()) {
+		age--
+	}
+	return age
+}
+
+// AccountUpdate lists the account fields to change, nil fields are left as
+// they are. OldPassword is the current password, needed to change it.
+type AccountUpdate struct {
+	Name        *string
+	Password    *string
+	OldPassword string
+	Premium     *bool
+}
+
+// ProfileUpdate lists the profile fields to change, nil fields are left as
+// they are.
+type ProfileUpdate struct {
+	BirthDate    *time.Time
+	Gender       *string
+	InterestedIn *[]string
+	Bio          *string
 }
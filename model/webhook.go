@@ -0,0 +1,92 @@
+package model
+
+import (
+	"time"
+)
+
+type WebhookEventType string
+
+const (
+	WebhookUserCreated  WebhookEventType = "user.created"
+	WebhookSwipe        WebhookEventType = "swipe"
+	WebhookMatchCreated WebhookEventType = "match.created"
+	WebhookMatchDeleted WebhookEventType = "match.deleted"
+)
+
+// WebhookEventTypes lists the event types webhooks can subscribe to.
+var WebhookEventTypes = []WebhookEventType{WebhookUserCreated, WebhookSwipe, WebhookMatchCreated, WebhookMatchDeleted}
+
+// IsWebhookEventType reports whether s is one of WebhookEventTypes.
+func IsWebhookEventType(s string) bool {
+	for _, t := range WebhookEventTypes {
+		if string(t) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook is a subscription of Url to the events of EventTypes. The
+// deliveries are signed with Secret.
+type Webhook struct {
+	Id         int64
+	Url        string
+	EventTypes []string `pg:",array"`
+	Secret     string
+	CreatedAt  time.Time
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryFailed deliveries gave up retrying, they are only attempted
+	// again when replayed.
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is the outbox row of one event for one webhook. Payload is
+// the JSON body posted, a WebhookPayload. Pending deliveries are attempted
+// at NextAttemptAt; LastStatus and LastError tell how the last attempt went,
+// LastStatus is 0 when no response was received.
+type WebhookDelivery struct {
+	Id            int64
+	Webhookid     int64
+	EventType     WebhookEventType
+	Payload       string
+	Status        DeliveryStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	LastStatus    int
+	LastError     string
+	CreatedAt     time.Time
+	DeliveredAt   time.Time `sql:",null"`
+}
+
+// WebhookPayload is the body posted to webhooks, Data is one of the
+// Webhook*Data types according to Type.
+type WebhookPayload struct {
+	Type      WebhookEventType
+	CreatedAt time.Time
+	Data      interface{}
+}
+
+type WebhookUserData struct {
+	UserId int64
+	Name   string
+}
+
+// WebhookSwipeData is a swipe of UserId on OtherUserId, State is the stored
+// state of the relation: liked, disliked or matched.
+type WebhookSwipeData struct {
+	UserId      int64
+	OtherUserId int64
+	State       RelationStatusDescription
+}
+
+type WebhookMatchData struct {
+	MatchId     int64
+	UserId      int64
+	OtherUserId int64
+}
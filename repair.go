@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/dao"
+)
+
+const repairUsage = "usage: simple-http-server repair [dry-run]"
+
+// runRepair executes the "repair" sub command: it reports the user names
+// shared by several users, the user pairs with several relations and the
+// relations pointing to deleted users, then renames those users, keeps the
+// latest relation of those pairs and deletes those relations unless dry-run
+// is given.
+func runRepair(conf *config.Config, args []string) error {
+	if conf.Storage == dao.StorageMemory {
+		return errors.New("repair only applies to the postgres storage")
+	}
+	dryRun := false
+	if len(args) == 1 && args[0] == "dry-run" {
+		dryRun = true
+	} else if len(args) > 0 {
+		return errors.New(repairUsage)
+	}
+
+	duplicates, err := dao.GetDuplicateUserNames(conf)
+	if err != nil {
+		return err
+	}
+	for _, d := range duplicates {
+		fmt.Printf("Duplicate user name %q: users %v\n", d.Name, d.Ids)
+	}
+	fmt.Printf("Found %d duplicate user names. \n", len(duplicates))
+
+	duplicateRelations, err := dao.GetDuplicateRelations(conf)
+	if err != nil {
+		return err
+	}
+	for _, d := range duplicateRelations {
+		fmt.Printf("Duplicate relations of user %d -> user %d: relations %v\n", d.Userid, d.Otheruserid, d.Ids)
+	}
+	fmt.Printf("Found %d user pairs with duplicate relations. \n", len(duplicateRelations))
+
+	orphans, err := dao.GetOrphanRelations(conf)
+	if err != nil {
+		return err
+	}
+	for _, r := range orphans {
+		fmt.Printf("Orphan relation %d: user %d -> user %d (%s)\n", r.Id, r.Userid, r.Otheruserid, r.Status.ToRelationStatusDescription())
+	}
+	fmt.Printf("Found %d orphan relations. \n", len(orphans))
+	if dryRun {
+		return nil
+	}
+
+	renamed, err := dao.RenameDuplicateUserNames(conf)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Renamed %d users to \"<name>#<id>\", the oldest user keeps each name. \n", renamed)
+
+	duplicated, err := dao.DeleteDuplicateRelations(conf)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %d duplicate relations, the latest relation of each pair is kept. \n", duplicated)
+
+	deleted, err := dao.DeleteOrphanRelations(conf)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %d orphan relations. \n", deleted)
+	return nil
+}
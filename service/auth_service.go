@@ -0,0 +1,54 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/auth"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+
+	"time"
+)
+
+type AuthService struct {
+}
+
+// Login checks the credentials of the user called name and returns a token
+// for it and the time the token expires.
+func (a *AuthService) Login(conf *config.Config, name string, password string) (string, time.Time, error) {
+	if conf.AuthSecret == "" {
+		return "", time.Time{}, model.NewValidationError("Authentication is disabled on this server. ")
+	}
+	user, err := userDao.GetUserByName(conf, name)
+	if model.IsNotFound(err) {
+		// Checked all the same so that unknown names answer as slowly.
+		auth.CheckPassword("", password)
+		return "", time.Time{}, model.NewUnauthorizedError("Wrong name or password! ")
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !auth.CheckPassword(user.PasswordHash, password) {
+		return "", time.Time{}, model.NewUnauthorizedError("Wrong name or password! ")
+	}
+	touchUser(conf, user.Id)
+	token, expiresAt := a.IssueToken(conf, user.Id, auth.RoleUser)
+	return token, expiresAt, nil
+}
+
+// IssueToken returns a token for userId with role, valid for
+// conf.AuthTokenTtl seconds, and the time it expires.
+func (*AuthService) IssueToken(conf *config.Config, userId int64, role string) (string, time.Time) {
+	ttl := time.Duration(conf.AuthTokenTtl) * time.Second
+	return auth.NewToken([]byte(conf.AuthSecret), userId, role, ttl, time.Now())
+}
+
+// Authenticate verifies token and returns its claims.
+func (*AuthService) Authenticate(conf *config.Config, token string) (*auth.Claims, error) {
+	claims, err := auth.ParseToken([]byte(conf.AuthSecret), token, time.Now())
+	if err == auth.ErrExpiredToken {
+		return nil, model.NewError(model.ErrorUnauthorized, err, "Token is expired. ")
+	}
+	if err != nil {
+		return nil, model.NewError(model.ErrorUnauthorized, err, "Token is invalid. ")
+	}
+	return claims, nil
+}
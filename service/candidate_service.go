@@ -0,0 +1,110 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/model"
+
+	"log/slog"
+)
+
+type CandidateService struct {
+}
+
+// GetCandidates returns up to limit users to suggest to userId from cursor
+// on, and the cursor of the next page, nil on the last page. The users who
+// already like userId are suggested first. A positive radiusKm keeps the
+// users within that distance of userId, who must have a location. It is
+// rounded up to a distance bucket like the distances shown, otherwise
+// narrowing it would tell the exact distance of a user.
+func (*CandidateService) GetCandidates(conf *config.Config, userId int64, cursor model.CandidateCursor, limit int, radiusKm float64) ([]model.Candidate, *model.CandidateCursor, error) {
+	viewer, err := userDao.GetUserById(conf, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	var near *model.Circle
+	if radiusKm > 0 {
+		if !viewer.HasLocation() {
+			return nil, nil, model.NewValidationError("Location is required to search by distance! ")
+		}
+		near = &model.Circle{Latitude: *viewer.Latitude, Longitude: *viewer.Longitude, RadiusKm: float64(model.RoundDistanceKm(radiusKm))}
+	}
+	candidates := []model.Candidate{}
+	if cursor.Likers {
+		likers, err := userDao.GetCandidates(conf, userId, true, near, model.Page{AfterId: cursor.AfterId, Limit: limit + 1})
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(likers) > limit {
+			candidates = appendCandidates(candidates, viewer, likers[:limit], true)
+			return candidates, &model.CandidateCursor{Likers: true, AfterId: likers[limit-1].Id}, nil
+		}
+		candidates = appendCandidates(candidates, viewer, likers, true)
+		cursor = model.CandidateCursor{Likers: false, AfterId: 0}
+	}
+	rest := limit - len(candidates)
+	others, err := userDao.GetCandidates(conf, userId, false, near, model.Page{AfterId: cursor.AfterId, Limit: rest + 1})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(others) <= rest {
+		return appendCandidates(candidates, viewer, others, false), nil, nil
+	}
+	next := &model.CandidateCursor{Likers: false, AfterId: cursor.AfterId}
+	if rest > 0 {
+		next.AfterId = others[rest-1].Id
+	}
+	return appendCandidates(candidates, viewer, others[:rest], false), next, nil
+}
+
+func appendCandidates(candidates []model.Candidate, viewer *model.User, users []model.User, likesViewer bool) []model.Candidate {
+	for _, user := range users {
+		candidate := model.Candidate{User: user, LikesViewer: likesViewer, DistanceKm: distanceBetween(viewer, &user)}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// distanceBetween returns nil when either user has no location.
+func distanceBetween(viewer *model.User, user *model.User) *float64 {
+	if !viewer.HasLocation() || !user.HasLocation() {
+		return nil
+	}
+	d := model.DistanceKm(*viewer.Latitude, *viewer.Longitude, *user.Latitude, *user.Longitude)
+	return &d
+}
+
+// Block hides blockedUserId from the candidates of userId and the other way
+// round, and reports whether the block is new.
+func (*CandidateService) Block(conf *config.Config, userId int64, blockedUserId int64) (bool, error) {
+	if err := checkBlockPair(conf, userId, blockedUserId); err != nil {
+		return false, err
+	}
+	b, err := relationDao.AddBlock(conf, userId, blockedUserId)
+	if err != nil {
+		return false, err
+	}
+	if b {
+		slog.Info("user blocked", "user_id", userId, "blocked_user_id", blockedUserId)
+	}
+	return b, nil
+}
+
+// Unblock lifts the block of userId on blockedUserId and reports whether
+// there was one.
+func (*CandidateService) Unblock(conf *config.Config, userId int64, blockedUserId int64) (bool, error) {
+	if err := checkBlockPair(conf, userId, blockedUserId); err != nil {
+		return false, err
+	}
+	return relationDao.DeleteBlock(conf, userId, blockedUserId)
+}
+
+func checkBlockPair(conf *config.Config, userId int64, blockedUserId int64) error {
+	if userId == blockedUserId {
+		return model.NewValidationError("Users can not block themselves! ")
+	}
+	if _, err := userDao.GetUserById(conf, userId); err != nil {
+		return err
+	}
+	_, err := userDao.GetUserById(conf, blockedUserId)
+	return err
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/model"
+
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCandidateRadiusIsRoundedToDistanceBuckets(t *testing.T) {
+	for storage, conf := range testConfigs(t) {
+		t.Run(storage, func(t *testing.T) {
+			initTestStorage(t, conf)
+			locate := func(user *model.User, latitude float64) {
+				now := time.Now()
+				if _, err := userDao.SetUserLocation(conf, user.Id, latitude, 2.3522, now, now); err != nil {
+					t.Fatal(err)
+				}
+			}
+			viewer := addTestUser(t, conf, "viewer")
+			locate(viewer, 48.8566)
+			// A degree of latitude is about 111 km.
+			names := map[int64]string{}
+			for _, km := range []float64{3, 7, 15} {
+				user := addTestUser(t, conf, fmt.Sprintf("at%.0fkm", km))
+				locate(user, 48.8566+km/111.19)
+				names[user.Id] = fmt.Sprintf("%.0f km", km)
+			}
+
+			service := &CandidateService{}
+			within := func(radiusKm float64) []string {
+				candidates, _, err := service.GetCandidates(conf, viewer.Id, model.CandidateCursor{Likers: true}, 100, radiusKm)
+				if err != nil {
+					t.Fatalf("fail to get candidates within %v km: %v", radiusKm, err)
+				}
+				found := []string{}
+				for _, candidate := range candidates {
+					if name, ok := names[candidate.Id]; ok {
+						found = append(found, name)
+					}
+				}
+				return found
+			}
+			for _, radii := range [][]float64{{0.01, 1}, {2.5, 4, 5}, {5.5, 6.9, 7.1, 10}, {10.5, 14.9, 20}} {
+				want := within(radii[len(radii)-1])
+				for _, radiusKm := range radii[:len(radii)-1] {
+					if got := within(radiusKm); !reflect.DeepEqual(got, want) {
+						t.Errorf("candidates within %v km are %v, want %v like within %v km", radiusKm, got, want, radii[len(radii)-1])
+					}
+				}
+			}
+			if got, want := within(6), []string{"3 km", "7 km"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("candidates within 6 km are %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/dao"
+	"github.com/tangyang/simple-http-server/model"
+
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var conversationDao dao.ConversationStore
+
+// maxMessageLength is the maximum number of characters of a message.
+const maxMessageLength = 2000
+
+type ChatService struct {
+}
+
+// GetMatches returns one page of the open conversations of userId and the id
+// to continue after, which is 0 on the last page.
+func (*ChatService) GetMatches(conf *config.Config, userId int64, page model.Page) ([]model.Match, int64, error) {
+	if _, err := userDao.GetUserById(conf, userId); err != nil {
+		return nil, 0, err
+	}
+	conversations, err := conversationDao.GetOpenConversations(conf, userId, model.Page{AfterId: page.AfterId, Limit: page.Limit + 1})
+	if err != nil {
+		return nil, 0, err
+	}
+	var next int64
+	if len(conversations) > page.Limit {
+		conversations = conversations[:page.Limit]
+		next = conversations[page.Limit-1].Id
+	}
+	ids := make([]int64, 0, len(conversations))
+	for _, conversation := range conversations {
+		ids = append(ids, conversation.Id)
+	}
+	unread, err := conversationDao.CountUnread(conf, userId, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	matches := make([]model.Match, 0, len(conversations))
+	for _, conversation := range conversations {
+		matches = append(matches, model.Match{Conversation: conversation, Unread: unread[conversation.Id]})
+	}
+	return matches, next, nil
+}
+
+// SendMessage sends body from userId in the conversation of match matchId,
+// and returns the message with its conversation.
+func (*ChatService) SendMessage(conf *config.Config, userId int64, matchId int64, body string) (*model.Message, *model.Conversation, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, nil, model.NewValidationError("Message can not be empty! ")
+	}
+	if utf8.RuneCountInString(body) > maxMessageLength {
+		return nil, nil, model.NewValidationError("Message can not be longer than %d characters! ", maxMessageLength)
+	}
+	conversation, err := getOpenConversation(conf, userId, matchId)
+	if err != nil {
+		return nil, nil, err
+	}
+	message := &model.Message{Conversationid: matchId, Senderid: userId, Body: body, CreatedAt: time.Now()}
+	// The sender gets the message too, for its other connections.
+	var events []model.Event
+	for _, member := range []int64{conversation.OtherMember(userId), userId} {
+		events = append(events, model.Event{Type: model.EventMessage, UserId: member, OtherUserId: conversation.OtherMember(member),
+			MatchId: matchId, Message: message, CreatedAt: message.CreatedAt})
+	}
+	added, err := conversationDao.AddMessage(conf, message, events)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !added {
+		return nil, nil, closedConversationError(matchId)
+	}
+	messagesTotal.Inc()
+	touchUser(conf, userId)
+	return message, conversation, nil
+}
+
+// GetMessages returns one page of the messages of match matchId from the
+// newest one, the id of the message to continue from, which is 0 on the
+// last page, and the conversation to tell which messages were read.
+func (*ChatService) GetMessages(conf *config.Config, userId int64, matchId int64, page model.Page) ([]model.Message, int64, *model.Conversation, error) {
+	conversation, err := getOpenConversation(conf, userId, matchId)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	messages, err := conversationDao.GetMessages(conf, matchId, model.Page{AfterId: page.AfterId, Limit: page.Limit + 1})
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if len(messages) <= page.Limit {
+		return messages, 0, conversation, nil
+	}
+	messages = messages[:page.Limit]
+	return messages, messages[page.Limit-1].Id, conversation, nil
+}
+
+// MarkRead records that userId read the messages of match matchId up to
+// messageId.
+func (*ChatService) MarkRead(conf *config.Config, userId int64, matchId int64, messageId int64) error {
+	if _, err := getOpenConversation(conf, userId, matchId); err != nil {
+		return err
+	}
+	found, err := conversationDao.MarkRead(conf, matchId, userId, messageId)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return model.NewNotFoundError("Message %d does not exist", messageId)
+	}
+	return nil
+}
+
+// getOpenConversation returns the conversation of match matchId, which only
+// its two users can use while they are matched.
+func getOpenConversation(conf *config.Config, userId int64, matchId int64) (*model.Conversation, error) {
+	conversation, err := conversationDao.GetConversation(conf, matchId)
+	if err != nil {
+		return nil, err
+	}
+	if !conversation.HasMember(userId) {
+		return nil, model.NewForbiddenError("User %d is not part of match %d! ", userId, matchId)
+	}
+	if !conversation.IsOpen() {
+		return nil, closedConversationError(matchId)
+	}
+	return conversation, nil
+}
+
+func closedConversationError(matchId int64) error {
+	return model.NewForbiddenError("Match %d was undone, its conversation is closed! ", matchId)
+}
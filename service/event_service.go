@@ -0,0 +1,36 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/dao"
+	"github.com/tangyang/simple-http-server/event"
+	"github.com/tangyang/simple-http-server/model"
+)
+
+// eventHub delivers the events to the connections open on this server, it
+// is created by InitStorage and fed by eventDao.
+var eventHub *event.Hub
+
+var eventDao dao.EventStore
+
+type EventService struct {
+}
+
+// Subscribe returns a subscription to the events of userId, which must be
+// closed once the connection is over.
+func (*EventService) Subscribe(conf *config.Config, userId int64) (*event.Subscription, error) {
+	if _, err := userDao.GetUserById(conf, userId); err != nil {
+		return nil, err
+	}
+	return eventHub.Subscribe(userId, conf.EventBuffer), nil
+}
+
+// Resume subscribes to the events of userId and returns the events
+// published after the event lastId, and whether none of them is missing.
+func (*EventService) Resume(conf *config.Config, userId int64, lastId int64) (*event.Subscription, []model.Event, bool, error) {
+	if _, err := userDao.GetUserById(conf, userId); err != nil {
+		return nil, nil, false, err
+	}
+	subscription, events, complete := eventHub.Resume(userId, conf.EventBuffer, lastId)
+	return subscription, events, complete, nil
+}
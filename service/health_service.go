@@ -0,0 +1,44 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/dao"
+
+	"time"
+)
+
+type HealthService struct {
+}
+
+// PingStorage checks that the storage backend answers and returns how long
+// it took.
+func (*HealthService) PingStorage(conf *config.Config) (time.Duration, error) {
+	start := time.Now()
+	if conf.Storage == dao.StorageMemory {
+		return time.Since(start), nil
+	}
+	err := dao.Ping(conf)
+	return time.Since(start), err
+}
+
+// GetPoolStats returns the database pool stats, nil for the memory storage.
+func (*HealthService) GetPoolStats(conf *config.Config) (*dao.PoolStats, error) {
+	if conf.Storage == dao.StorageMemory {
+		return nil, nil
+	}
+	return dao.GetPoolStats(conf)
+}
+
+// GetSchemaVersion returns the applied schema version. The memory storage is
+// always up to date.
+func (*HealthService) GetSchemaVersion(conf *config.Config) (int, error) {
+	if conf.Storage == dao.StorageMemory {
+		return dao.LatestSchemaVersion(), nil
+	}
+	return dao.GetSchemaVersion(conf)
+}
+
+// LatestSchemaVersion returns the schema version this build expects.
+func (*HealthService) LatestSchemaVersion() int {
+	return dao.LatestSchemaVersion()
+}
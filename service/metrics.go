@@ -0,0 +1,22 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/metrics"
+)
+
+var (
+	swipesTotal = metrics.NewCounterVec("swipes_total",
+		"Number of swipes recorded, by requested state.", "state")
+	matchesTotal = metrics.NewCounterVec("matches_total",
+		"Number of matches created.")
+	unmatchesTotal = metrics.NewCounterVec("unmatches_total",
+		"Number of matches undone by a dislike or a removed swipe.")
+	likeQuotaExceededTotal = metrics.NewCounterVec("like_quota_exceeded_total",
+		"Number of likes refused because the daily like quota was spent.")
+	messagesTotal = metrics.NewCounterVec("messages_total",
+		"Number of chat messages sent.")
+	webhookDeliveriesTotal = metrics.NewCounterVec("webhook_deliveries_total",
+		"Number of webhook delivery attempts, by result: delivered, retried or failed.", "result")
+	eventSubscribers = metrics.NewGaugeFunc("event_subscribers",
+		"Number of open real-time connections.", func() float64 { return float64(eventHub.Subscribers()) })
+)
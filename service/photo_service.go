@@ -0,0 +1,217 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/blob"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/dao"
+	"github.com/tangyang/simple-http-server/model"
+
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"time"
+)
+
+var photoDao dao.PhotoStore
+var blobStore blob.Store
+
+const (
+	// maxPhotoDimension bounds the width and height of uploads, which also
+	// bounds the memory needed to decode them.
+	maxPhotoDimension = 8000
+	thumbnailSize     = 320
+	photoJpegQuality  = 90
+	thumbnailQuality  = 80
+)
+
+type PhotoService struct {
+}
+
+// AddPhoto validates the JPEG or PNG image read from r and appends it to the
+// photos of userId, with a thumbnail. The image is decoded and encoded again
+// before being stored, which drops its metadata such as GPS coordinates.
+func (*PhotoService) AddPhoto(conf *config.Config, userId int64, r io.Reader) (*model.Photo, error) {
+	if _, err := userDao.GetUserById(conf, userId); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(conf.PhotoMaxBytes)+1))
+	if err != nil {
+		return nil, model.NewValidationError("Fail to read photo. ")
+	}
+	if len(data) > conf.PhotoMaxBytes {
+		return nil, model.NewValidationError("Photo can not be larger than %d bytes! ", conf.PhotoMaxBytes)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "jpeg" && format != "png") {
+		return nil, model.NewValidationError("Photo must be a JPEG or PNG image! ")
+	}
+	if cfg.Width > maxPhotoDimension || cfg.Height > maxPhotoDimension {
+		return nil, model.NewValidationError("Photo can not be larger than %dx%d pixels! ", maxPhotoDimension, maxPhotoDimension)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, model.NewValidationError("Photo must be a JPEG or PNG image! ")
+	}
+
+	photo := &model.Photo{Userid: userId, Width: cfg.Width, Height: cfg.Height, CreatedAt: time.Now()}
+	name := newBlobName()
+	var original bytes.Buffer
+	if format == "png" {
+		photo.ContentType, photo.Key = "image/png", name+".png"
+		err = png.Encode(&original, img)
+	} else {
+		photo.ContentType, photo.Key = "image/jpeg", name+".jpg"
+		err = jpeg.Encode(&original, img, &jpeg.Options{Quality: photoJpegQuality})
+	}
+	if err != nil {
+		return nil, model.NewError(model.ErrorInternal, err, "Fail to encode photo")
+	}
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, thumbnail(img, thumbnailSize), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, model.NewError(model.ErrorInternal, err, "Fail to encode thumbnail")
+	}
+	photo.ThumbnailKey = name + "_thumb.jpg"
+
+	if err := blobStore.Put(photo.Key, &original); err != nil {
+		return nil, model.NewError(model.ErrorInternal, err, "Fail to store photo")
+	}
+	if err := blobStore.Put(photo.ThumbnailKey, &thumb); err != nil {
+		deleteBlobs(photo.Key)
+		return nil, model.NewError(model.ErrorInternal, err, "Fail to store thumbnail")
+	}
+	added, err := photoDao.AddPhoto(conf, photo, conf.MaxPhotos)
+	if err != nil || !added {
+		deleteBlobs(photo.Key, photo.ThumbnailKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, model.NewConflictError("A user can not have more than %d photos! ", conf.MaxPhotos)
+	}
+	return photo, nil
+}
+
+func (*PhotoService) GetPhotos(conf *config.Config, userId int64) ([]model.Photo, error) {
+	if _, err := userDao.GetUserById(conf, userId); err != nil {
+		return nil, err
+	}
+	return photoDao.GetPhotos(conf, userId)
+}
+
+// GetPhotosByUsers returns the photos of each of userIds, by user id.
+func (*PhotoService) GetPhotosByUsers(conf *config.Config, userIds []int64) (map[int64][]model.Photo, error) {
+	photos, err := photoDao.GetPhotosByUserIds(conf, userIds)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int64][]model.Photo)
+	for _, photo := range photos {
+		result[photo.Userid] = append(result[photo.Userid], photo)
+	}
+	return result, nil
+}
+
+func (*PhotoService) DeletePhoto(conf *config.Config, userId int64, photoId int64) error {
+	photo, err := photoDao.DeletePhoto(conf, userId, photoId)
+	if err != nil {
+		return err
+	}
+	deleteBlobs(photo.Key, photo.ThumbnailKey)
+	return nil
+}
+
+// ReorderPhotos orders the photos of userId as photoIds, the first one
+// becomes the primary photo.
+func (*PhotoService) ReorderPhotos(conf *config.Config, userId int64, photoIds []int64) error {
+	return photoDao.ReorderPhotos(conf, userId, photoIds)
+}
+
+// OpenPhoto opens the image or thumbnail stored under key.
+func (*PhotoService) OpenPhoto(key string) (io.ReadCloser, error) {
+	f, err := blobStore.Get(key)
+	if err == blob.ErrNotFound {
+		return nil, model.NewNotFoundError("Photo does not exist")
+	}
+	if err != nil {
+		return nil, model.NewError(model.ErrorInternal, err, "Fail to open photo %s", key)
+	}
+	return f, nil
+}
+
+// deleteBlobs removes blobs no longer referenced. Failures only leave
+// unreachable files behind, they are logged and not returned.
+func deleteBlobs(keys ...string) {
+	for _, key := range keys {
+		if err := blobStore.Delete(key); err != nil {
+			slog.Warn("fail to delete blob", "key", key, "error", err.Error())
+		}
+	}
+}
+
+// newBlobName returns a random name, photo URLs can not be guessed.
+func newBlobName() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// thumbnail scales img down to fit in size x size pixels, averaging a few
+// samples of the source area of each pixel, on a white background.
+func thumbnail(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, h*size/w
+		} else {
+			tw, th = w*size/h, size
+		}
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			dst.Set(x, y, averageColor(img, x0, y0, x1, y1))
+		}
+	}
+	return dst
+}
+
+// averageColor averages up to 4x4 samples of the rectangle, composited over
+// white since thumbnails are JPEG.
+func averageColor(img image.Image, x0 int, y0 int, x1 int, y1 int) color.Color {
+	stepX, stepY := (x1-x0+3)/4, (y1-y0+3)/4
+	var r, g, b, n uint64
+	for sy := y0; sy < y1; sy += stepY {
+		for sx := x0; sx < x1; sx += stepX {
+			cr, cg, cb, ca := img.At(sx, sy).RGBA()
+			white := uint64(0xffff - ca)
+			r += uint64(cr) + white
+			g += uint64(cg) + white
+			b += uint64(cb) + white
+			n++
+		}
+	}
+	return color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: 0xffff}
+}
@@ -5,30 +5,265 @@ import (
 	"github.com/tangyang/simple-http-server/dao"
 	"github.com/tangyang/simple-http-server/model"
 
-	"fmt"
+	"log/slog"
+	"time"
 )
 
-var relationDao *dao.RelationDao = &dao.RelationDao{}
+var relationDao dao.RelationStore
 
 type RelationService struct {
 }
 
-func (*RelationService) AddRelation(conf *config.Config, relation *model.Relation) (bool, error) {
-	existRelation := relationDao.GetRelationByUserIdPairs(conf, relation.Otheruserid, relation.Userid)
-	if existRelation != nil && existRelation.Status == model.RelationLike && relation.Status == model.RelationLike {
-		fmt.Sprintf("User %d is already liked by %d \n", relation.Userid, relation.Otheruserid)
-		relation.Status = model.RelationMatched
-		b, err := relationDao.AddOrUpdateRelation(conf, relation)
-		if b {
-			existRelation.Status = model.RelationMatched
-			err = relationDao.UpdateRelation(conf, existRelation)
+// AddRelation records a swipe, or changes an earlier one, and returns
+// whether a new relation row was created. relation.Status must be
+// RelationLike or RelationDislike; on return it holds the stored state.
+//
+// The transition rules are:
+//   - liking someone who likes (or matched) you matches both sides;
+//   - liking someone who has not liked you stores a plain like;
+//   - disliking someone stores a dislike, and when the pair was matched the
+//     other side falls back to liking you.
+//
+// A match opens the conversation of the pair, undoing it closes it. The
+// users are notified of likes, matches and unmatches when the transaction
+// commits.
+//
+// The reverse relation is read and both rows are written in one
+// transaction holding the pair lock, so two users liking each other at the
+// same time always end up matched on both sides.
+//
+// Likes of non premium users count against their daily like quota, whose
+// state is returned for likes; once it is spent AddRelation fails with a too
+// many requests error.
+func (*RelationService) AddRelation(conf *config.Config, relation *model.Relation) (bool, *model.LikeQuota, error) {
+	if relation.Status != model.RelationLike && relation.Status != model.RelationDislike {
+		return false, nil, model.NewValidationError("Bad parameter status")
+	}
+	user, err := checkUserPair(conf, relation.Userid, relation.Otheruserid)
+	if err != nil {
+		return false, nil, err
+	}
+	var quota *model.LikeQuota
+	if relation.Status == model.RelationLike {
+		quota = likeQuota(conf, user, time.Now())
+	}
+	requested := relation.Status
+	var created, matched, unmatched bool
+	err = relationDao.RunInTransaction(conf, func(store dao.RelationStore) error {
+		created, matched, unmatched = false, false, false
+		if err := store.LockUserPair(conf, relation.Userid, relation.Otheruserid); err != nil {
+			return err
+		}
+		current, err := store.GetRelationByUserIdPairs(conf, relation.Userid, relation.Otheruserid)
+		if err != nil && !model.IsNotFound(err) {
+			return err
+		}
+		if quota != nil {
+			if err := spendLike(conf, store, relation, current, quota); err != nil {
+				return err
+			}
+		}
+		reverse, err := getReverseRelation(conf, store, relation.Userid, relation.Otheruserid)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		var events []model.Event
+		if relation.Status == model.RelationLike && reverse != nil && reverse.Status != model.RelationDislike {
+			relation.Status = model.RelationMatched
+			if reverse.Status != model.RelationMatched {
+				reverse.Status = model.RelationMatched
+				if err := store.UpdateRelation(conf, reverse); err != nil {
+					return err
+				}
+				matchId, err := store.OpenConversation(conf, relation.Userid, relation.Otheruserid, now)
+				if err != nil {
+					return err
+				}
+				matched = true
+				events = pairEvents(model.EventMatch, relation.Userid, relation.Otheruserid, matchId, now)
+			}
+		} else if relation.Status == model.RelationLike {
+			if current == nil || current.Status == model.RelationDislike {
+				events = []model.Event{{Type: model.EventLike, UserId: relation.Otheruserid, OtherUserId: relation.Userid, CreatedAt: now}}
+			}
+		} else {
+			var matchId int64
+			if matchId, unmatched, err = unmatch(conf, store, reverse); err != nil {
+				return err
+			}
+			if unmatched {
+				events = pairEvents(model.EventUnmatch, relation.Userid, relation.Otheruserid, matchId, now)
+			}
+		}
+		if created, err = store.AddOrUpdateRelation(conf, relation); err != nil {
+			return err
+		}
+		return store.NotifyEvents(conf, events)
+	})
+	if err != nil {
+		if model.ErrorKindOf(err) == model.ErrorTooManyRequests {
+			likeQuotaExceededTotal.Inc()
+		}
+		return false, quota, err
+	}
+	touchUser(conf, relation.Userid)
+	swipesTotal.Inc(string(requested.ToRelationStatusDescription()))
+	if matched {
+		matchesTotal.Inc()
+		slog.Info("users matched", "user_id", relation.Userid, "other_user_id", relation.Otheruserid)
+	}
+	if unmatched {
+		unmatchesTotal.Inc()
+		slog.Info("users unmatched", "user_id", relation.Userid, "other_user_id", relation.Otheruserid)
+	}
+	return created, quota, nil
+}
+
+// RemoveRelation deletes the swipe of userId on otherUserId and reports
+// whether there was one. Removing one side of a match reverts the other side
+// to liked.
+func (*RelationService) RemoveRelation(conf *config.Config, userId int64, otherUserId int64) (bool, error) {
+	if _, err := checkUserPair(conf, userId, otherUserId); err != nil {
+		return false, err
+	}
+	var removed, unmatched bool
+	err := relationDao.RunInTransaction(conf, func(store dao.RelationStore) error {
+		removed, unmatched = false, false
+		if err := store.LockUserPair(conf, userId, otherUserId); err != nil {
+			return err
+		}
+		b, err := store.DeleteRelation(conf, userId, otherUserId)
+		if err != nil || !b {
+			return err
+		}
+		removed = true
+		reverse, err := getReverseRelation(conf, store, userId, otherUserId)
+		if err != nil {
+			return err
+		}
+		var matchId int64
+		if matchId, unmatched, err = unmatch(conf, store, reverse); err != nil {
+			return err
+		}
+		if !unmatched {
+			return nil
+		}
+		return store.NotifyEvents(conf, pairEvents(model.EventUnmatch, userId, otherUserId, matchId, time.Now()))
+	})
+	if err != nil {
+		return false, err
+	}
+	if unmatched {
+		unmatchesTotal.Inc()
+		slog.Info("users unmatched", "user_id", userId, "other_user_id", otherUserId)
+	}
+	return removed, nil
+}
+
+// checkUserPair validates that a relation between the two users can exist:
+// both users exist and they are different users. It returns userId's user.
+func checkUserPair(conf *config.Config, userId int64, otherUserId int64) (*model.User, error) {
+	if userId == otherUserId {
+		return nil, model.NewValidationError("Users can not swipe on themselves! ")
+	}
+	user, err := userDao.GetUserById(conf, userId)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := userDao.GetUserById(conf, otherUserId); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// likeQuota returns the daily like quota of user for the UTC day of now, or
+// nil when user has no quota.
+func likeQuota(conf *config.Config, user *model.User, now time.Time) *model.LikeQuota {
+	if conf.DailyLikeQuota <= 0 || user.Premium {
+		return nil
+	}
+	day := now.UTC().Truncate(24 * time.Hour)
+	return &model.LikeQuota{Limit: conf.DailyLikeQuota, Remaining: conf.DailyLikeQuota, ResetAt: day.Add(24 * time.Hour)}
+}
+
+// spendLike counts the like in relation against quota and updates the
+// remaining likes. Liking again a user already liked, or matched, is free;
+// current is the stored relation, nil when there is none.
+func spendLike(conf *config.Config, store dao.RelationStore, relation *model.Relation, current *model.Relation, quota *model.LikeQuota) error {
+	day := quota.ResetAt.Add(-24 * time.Hour)
+	var likes int
+	var err error
+	if current != nil && current.Status != model.RelationDislike {
+		likes, err = store.GetDailyLikes(conf, relation.Userid, day)
+		if err != nil {
+			return err
 		}
-		return b, err
 	} else {
-		return relationDao.AddOrUpdateRelation(conf, relation)
+		var counted bool
+		likes, counted, err = store.AddDailyLike(conf, relation.Userid, day, quota.Limit)
+		if err != nil {
+			return err
+		}
+		if !counted {
+			quota.Remaining = 0
+			return model.NewTooManyRequestsError("Daily like quota is spent! ")
+		}
+	}
+	quota.Remaining = quota.Limit - likes
+	if quota.Remaining < 0 {
+		quota.Remaining = 0
+	}
+	return nil
+}
+
+// getReverseRelation returns the swipe of otherUserId on userId, or nil when
+// there is none.
+func getReverseRelation(conf *config.Config, store dao.RelationStore, userId int64, otherUserId int64) (*model.Relation, error) {
+	reverse, err := store.GetRelationByUserIdPairs(conf, otherUserId, userId)
+	if model.IsNotFound(err) {
+		return nil, nil
+	}
+	return reverse, err
+}
+
+// unmatch reverts the other side of a broken match to a plain like, closes
+// the conversation of the match and reports whether there was a match to
+// break, with the id of the closed conversation.
+func unmatch(conf *config.Config, store dao.RelationStore, reverse *model.Relation) (int64, bool, error) {
+	if reverse == nil || reverse.Status != model.RelationMatched {
+		return 0, false, nil
+	}
+	reverse.Status = model.RelationLike
+	if err := store.UpdateRelation(conf, reverse); err != nil {
+		return 0, false, err
 	}
+	matchId, err := store.CloseConversation(conf, reverse.Userid, reverse.Otheruserid, time.Now())
+	return matchId, err == nil, err
 }
 
-func (r *RelationService) GetRelations(conf *config.Config, userId int64) []model.Relation {
-	return relationDao.GetAllRelationsByUserId(conf, userId)
+// pairEvents returns the events telling both users of a pair about a change
+// of their match.
+func pairEvents(eventType model.EventType, userId int64, otherUserId int64, matchId int64, now time.Time) []model.Event {
+	return []model.Event{
+		{Type: eventType, UserId: userId, OtherUserId: otherUserId, MatchId: matchId, CreatedAt: now},
+		{Type: eventType, UserId: otherUserId, OtherUserId: userId, MatchId: matchId, CreatedAt: now},
+	}
+}
+
+// GetRelations returns one page of the relations of userId selected by
+// filter and the id to continue after, which is 0 on the last page.
+func (r *RelationService) GetRelations(conf *config.Config, userId int64, filter model.RelationFilter, page model.Page) ([]model.Relation, int64, error) {
+	if _, err := userDao.GetUserById(conf, userId); err != nil {
+		return nil, 0, err
+	}
+	relations, err := relationDao.GetRelationsByUserId(conf, userId, filter, model.Page{AfterId: page.AfterId, Limit: page.Limit + 1})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(relations) <= page.Limit {
+		return relations, 0, nil
+	}
+	relations = relations[:page.Limit]
+	return relations, relations[page.Limit-1].Id, nil
 }
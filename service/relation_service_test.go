@@ -0,0 +1,122 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/dao"
+	"github.com/tangyang/simple-http-server/model"
+
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testConfigs returns the configs of the storages to test with: the memory
+// storage, and PostgreSQL when TEST_PG_ADDRESS is set. TEST_PG_USERNAME,
+// TEST_PG_PASSWORD and TEST_PG_DB_NAME override the default credentials.
+func testConfigs(t *testing.T) map[string]*config.Config {
+	newConfig := func(storage string) *config.Config {
+		return &config.Config{Storage: storage, BlobStorage: "local", BlobDir: t.TempDir(), EventReplay: 100,
+			PgPoolsize: 20, PgReadTimeout: 30, PgWriteTimeout: 30, PgIdleTimeout: 5,
+			PgUsername: "pger", PgPassword: "pger", PgDatabaseName: "pgerdb"}
+	}
+	configs := map[string]*config.Config{dao.StorageMemory: newConfig(dao.StorageMemory)}
+	if address := os.Getenv("TEST_PG_ADDRESS"); address != "" {
+		conf := newConfig(dao.StoragePostgres)
+		conf.PgAddress = address
+		for env, value := range map[string]*string{"TEST_PG_USERNAME": &conf.PgUsername,
+			"TEST_PG_PASSWORD": &conf.PgPassword, "TEST_PG_DB_NAME": &conf.PgDatabaseName} {
+			if v := os.Getenv(env); v != "" {
+				*value = v
+			}
+		}
+		configs[dao.StoragePostgres] = conf
+	}
+	return configs
+}
+
+// initTestStorage points the services to the storage of conf, migrated up.
+func initTestStorage(t *testing.T, conf *config.Config) {
+	if conf.Storage == dao.StoragePostgres {
+		if _, err := dao.MigrateUp(conf); err != nil {
+			t.Fatalf("fail to migrate: %v", err)
+		}
+	}
+	if err := InitStorage(conf); err != nil {
+		t.Fatalf("fail to init storage: %v", err)
+	}
+	t.Cleanup(func() { eventDao.Close() })
+}
+
+func addTestUser(t *testing.T, conf *config.Config, name string) *model.User {
+	user := &model.User{Name: fmt.Sprintf("%s-%d", name, time.Now().UnixNano())}
+	if _, err := userDao.AddUser(conf, user); err != nil {
+		t.Fatalf("fail to add user %s: %v", user.Name, err)
+	}
+	return user
+}
+
+func TestConcurrentMutualLikesMatch(t *testing.T) {
+	const pairs = 1000
+	for storage, conf := range testConfigs(t) {
+		t.Run(storage, func(t *testing.T) {
+			initTestStorage(t, conf)
+			users := make([][2]*model.User, pairs)
+			for i := range users {
+				users[i] = [2]*model.User{addTestUser(t, conf, fmt.Sprintf("a%d", i)), addTestUser(t, conf, fmt.Sprintf("b%d", i))}
+			}
+
+			service := &RelationService{}
+			start := make(chan struct{})
+			errs := make(chan error, 2*pairs)
+			var wg sync.WaitGroup
+			for _, pair := range users {
+				for _, side := range [][2]*model.User{{pair[0], pair[1]}, {pair[1], pair[0]}} {
+					wg.Add(1)
+					go func(userId int64, otherUserId int64) {
+						defer wg.Done()
+						<-start
+						relation := &model.Relation{Userid: userId, Otheruserid: otherUserId, Status: model.RelationLike}
+						if _, _, err := service.AddRelation(conf, relation); err != nil {
+							errs <- fmt.Errorf("user %d liking user %d: %v", userId, otherUserId, err)
+						}
+					}(side[0].Id, side[1].Id)
+				}
+			}
+			close(start)
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+
+			for _, pair := range users {
+				for _, side := range [][2]*model.User{{pair[0], pair[1]}, {pair[1], pair[0]}} {
+					relation, err := relationDao.GetRelationByUserIdPairs(conf, side[0].Id, side[1].Id)
+					if err != nil {
+						t.Fatalf("fail to get relation of user %d on user %d: %v", side[0].Id, side[1].Id, err)
+					}
+					if relation.Status != model.RelationMatched {
+						t.Errorf("relation of user %d on user %d is %s, want matched",
+							side[0].Id, side[1].Id, relation.Status.ToRelationStatusDescription())
+					}
+				}
+				conversations, err := conversationDao.GetOpenConversations(conf, pair[0].Id, model.Page{Limit: 10})
+				if err != nil {
+					t.Fatalf("fail to get conversations of user %d: %v", pair[0].Id, err)
+				}
+				if len(conversations) != 1 {
+					t.Errorf("users %d and %d have %d open conversations, want 1", pair[0].Id, pair[1].Id, len(conversations))
+				}
+			}
+		})
+	}
+}
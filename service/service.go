@@ -0,0 +1,42 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/blob"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/dao"
+	"github.com/tangyang/simple-http-server/event"
+
+	"fmt"
+)
+
+// InitStorage selects the storage backend used by every service according to
+// conf.Storage. It must be called before any service method.
+func InitStorage(conf *config.Config) error {
+	stores, err := dao.NewStores(conf)
+	if err != nil {
+		return err
+	}
+	if conf.BlobStorage != "local" {
+		return fmt.Errorf("unknown blob storage %q, expected local", conf.BlobStorage)
+	}
+	blobs, err := blob.NewLocalStore(conf.BlobDir)
+	if err != nil {
+		return err
+	}
+	userDao, relationDao, photoDao, conversationDao = stores.Users, stores.Relations, stores.Photos, stores.Conversations
+	blobStore = blobs
+	eventHub = event.NewHub(conf.EventReplay)
+	eventDao = stores.Events
+	eventDao.Listen(conf, eventHub)
+	webhookDao = stores.Webhooks
+	return nil
+}
+
+// CloseStorage stops listening to events and releases the resources held by
+// the storage backend, such as the database connection pool.
+func CloseStorage() error {
+	if err := eventDao.Close(); err != nil {
+		return err
+	}
+	return dao.Close()
+}
@@ -1,25 +1,268 @@
 package service
 
 import (
+	"github.com/tangyang/simple-http-server/auth"
 	"github.com/tangyang/simple-http-server/config"
 	"github.com/tangyang/simple-http-server/dao"
 	"github.com/tangyang/simple-http-server/model"
+
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
-var userDao *dao.UserDao = &dao.UserDao{}
+var userDao dao.UserStore
 
 type UserService struct {
 }
 
-func (*UserService) AddUser(conf *config.Config, user *model.User) (bool, error) {
+const (
+	minPasswordLength = 8
+	minAge            = 18
+	maxAge            = 120
+	maxBioLength      = 500
+)
+
+// AddUser creates the user with password, filling in its id. It fails with a
+// conflict error when the name is already taken. The password is required
+// when authentication is enabled, users without one can not log in.
+func (*UserService) AddUser(conf *config.Config, user *model.User, password string) error {
+	user.Name = strings.TrimSpace(user.Name)
+	if len(user.Name) <= 0 {
+		return model.NewValidationError("Name parameter is required! ")
+	}
+	if password == "" && conf.AuthSecret != "" {
+		return model.NewValidationError("Password parameter is required! ")
+	}
+	if password != "" {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = hash
+	}
+	user.LastActive = time.Now()
 	b, err := userDao.AddUser(conf, user)
-	return b, err
+	if err != nil {
+		return err
+	}
+	if !b {
+		return model.NewConflictError("Name already exists! ")
+	}
+	return nil
+}
+
+func (u *UserService) GetUser(conf *config.Config, id int64) (*model.User, error) {
+	return userDao.GetUserById(conf, id)
+}
+
+// GetDistance returns the distance between user and viewer viewerId, nil
+// when either of them has no location or when the viewer is the user.
+func (u *UserService) GetDistance(conf *config.Config, viewerId int64, user *model.User) (*float64, error) {
+	if viewerId == user.Id || !user.HasLocation() {
+		return nil, nil
+	}
+	viewer, err := userDao.GetUserById(conf, viewerId)
+	if err != nil {
+		return nil, err
+	}
+	return distanceBetween(viewer, user), nil
+}
+
+// UpdateAccount applies update to the account of user id and returns the
+// user. Every field is checked before anything is written, then the changes
+// are written at once. Only admins can change premium, and they can change a
+// password without the old one.
+func (u *UserService) UpdateAccount(conf *config.Config, id int64, update model.AccountUpdate, admin bool) (*model.User, error) {
+	var fields []model.FieldError
+	if update.Name != nil && strings.TrimSpace(*update.Name) == "" {
+		fields = append(fields, model.FieldError{Field: "name", Message: "Name can not be empty. "})
+	}
+	if update.Password != nil && len(*update.Password) < minPasswordLength {
+		fields = append(fields, model.FieldError{Field: "password", Message: fmt.Sprintf("Password must have at least %d characters. ", minPasswordLength)})
+	}
+	if len(fields) > 0 {
+		return nil, model.NewFieldValidationError(fields)
+	}
+	if update.Premium != nil && !admin {
+		return nil, model.NewForbiddenError("Only admins can change premium! ")
+	}
+	user, err := userDao.GetUserById(conf, id)
+	if err != nil {
+		return nil, err
+	}
+	passwordHash := user.PasswordHash
+	if update.Password != nil {
+		if !admin && user.PasswordHash != "" {
+			if update.OldPassword == "" {
+				return nil, model.NewValidationError("old_password parameter is required to change the password! ")
+			}
+			if !auth.CheckPassword(user.PasswordHash, update.OldPassword) {
+				return nil, model.NewForbiddenError("Old password does not match! ")
+			}
+		}
+		if user.PasswordHash, err = hashPassword(*update.Password); err != nil {
+			return nil, err
+		}
+	}
+	if update.Name != nil {
+		user.Name = strings.TrimSpace(*update.Name)
+	}
+	premium := user.Premium
+	if update.Premium != nil {
+		user.Premium = *update.Premium
+	}
+	if err := userDao.UpdateUser(conf, user, passwordHash); err != nil {
+		return nil, err
+	}
+	if user.Premium != premium {
+		slog.Info("user premium changed", "user_id", id, "premium", user.Premium)
+	}
+	return user, nil
+}
+
+// UpdateProfile applies update to the profile of user id and returns the
+// user. Every refused field is reported in the returned validation error.
+func (u *UserService) UpdateProfile(conf *config.Config, id int64, update model.ProfileUpdate) (*model.User, error) {
+	now := time.Now()
+	if fields := validateProfile(update, now); len(fields) > 0 {
+		return nil, model.NewFieldValidationError(fields)
+	}
+	user, err := userDao.GetUserById(conf, id)
+	if err != nil {
+		return nil, err
+	}
+	if update.BirthDate != nil {
+		user.BirthDate = *update.BirthDate
+	}
+	if update.Gender != nil {
+		user.Gender = *update.Gender
+	}
+	if update.InterestedIn != nil {
+		user.InterestedIn = *update.InterestedIn
+	}
+	if update.Bio != nil {
+		user.Bio = strings.TrimSpace(*update.Bio)
+	}
+	user.LastActive = now
+	if err := userDao.UpdateUserProfile(conf, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func validateProfile(update model.ProfileUpdate, now time.Time) []model.FieldError {
+	var fields []model.FieldError
+	if update.BirthDate != nil {
+		age := model.Age(*update.BirthDate, now)
+		if age < minAge {
+			fields = append(fields, model.FieldError{Field: "birth_date", Message: fmt.Sprintf("Users must be at least %d years old. ", minAge)})
+		} else if age > maxAge {
+			fields = append(fields, model.FieldError{Field: "birth_date", Message: "Birth date is too far in the past. "})
+		}
+	}
+	if update.Gender != nil && !model.IsGender(*update.Gender) {
+		fields = append(fields, model.FieldError{Field: "gender", Message: fmt.Sprintf("Gender must be one of %s, %s or %s. ", model.GenderFemale, model.GenderMale, model.GenderNonBinary)})
+	}
+	if update.InterestedIn != nil {
+		seen := make(map[string]bool)
+		for _, g := range *update.InterestedIn {
+			if !model.IsGender(g) || seen[g] {
+				fields = append(fields, model.FieldError{Field: "interested_in", Message: "Interested in must list distinct genders. "})
+				break
+			}
+			seen[g] = true
+		}
+	}
+	if update.Bio != nil && utf8.RuneCountInString(strings.TrimSpace(*update.Bio)) > maxBioLength {
+		fields = append(fields, model.FieldError{Field: "bio", Message: fmt.Sprintf("Bio can not be longer than %d characters. ", maxBioLength)})
+	}
+	return fields
+}
+
+// SetLocation records where user id is, validating the coordinates. A user
+// can only move once every c.LocationInterval seconds, otherwise the
+// rounded distances shown to a viewer moving around would reveal where the
+// other users are.
+func (u *UserService) SetLocation(conf *config.Config, id int64, latitude float64, longitude float64) error {
+	var fields []model.FieldError
+	if math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+		fields = append(fields, model.FieldError{Field: "latitude", Message: "Latitude must be between -90 and 90. "})
+	}
+	if math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+		fields = append(fields, model.FieldError{Field: "longitude", Message: "Longitude must be between -180 and 180. "})
+	}
+	if len(fields) > 0 {
+		return model.NewFieldValidationError(fields)
+	}
+	now := time.Now()
+	set, err := userDao.SetUserLocation(conf, id, latitude, longitude, now, now.Add(-time.Duration(conf.LocationInterval)*time.Second))
+	if err != nil {
+		return err
+	}
+	if !set {
+		return model.NewTooManyRequestsError("Location can only be updated every %d seconds, retry later. ", conf.LocationInterval)
+	}
+	return nil
+}
+
+// touchUser records that user id is active now. A failure only costs a
+// stale last active time, so it is logged and not returned.
+func touchUser(conf *config.Config, id int64) {
+	if err := userDao.TouchUser(conf, id, time.Now()); err != nil {
+		slog.Warn("fail to touch user", "user_id", id, "error", err.Error())
+	}
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", model.NewValidationError("Password must have at least %d characters! ", minPasswordLength)
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return "", model.NewError(model.ErrorInternal, err, "Fail to hash password")
+	}
+	return hash, nil
+}
+
+// DeleteUser deletes user id, its photos and all the relations it takes
+// part in.
+func (u *UserService) DeleteUser(conf *config.Config, id int64) error {
+	photos, err := photoDao.GetPhotos(conf, id)
+	if err != nil {
+		return err
+	}
+	b, err := userDao.DeleteUser(conf, id)
+	if err != nil {
+		return err
+	}
+	if !b {
+		return model.NewNotFoundError("User %d does not exist", id)
+	}
+	for _, photo := range photos {
+		deleteBlobs(photo.Key, photo.ThumbnailKey)
+	}
+	slog.Info("user deleted", "user_id", id)
+	return nil
 }
 
-func (u *UserService) GetUserByName(conf *config.Config, name string) *model.User {
+func (u *UserService) GetUserByName(conf *config.Config, name string) (*model.User, error) {
 	return userDao.GetUserByName(conf, name)
 }
 
-func (u *UserService) GetAllUsers(conf *config.Config) []model.User {
-	return userDao.GetAllUsers(conf)
+// GetUsers returns one page of users and the id to continue after, which is
+// 0 on the last page.
+func (u *UserService) GetUsers(conf *config.Config, page model.Page) ([]model.User, int64, error) {
+	users, err := userDao.GetUsers(conf, model.Page{AfterId: page.AfterId, Limit: page.Limit + 1})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(users) <= page.Limit {
+		return users, 0, nil
+	}
+	users = users[:page.Limit]
+	return users, users[page.Limit-1].Id, nil
 }
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/dao"
+	"github.com/tangyang/simple-http-server/model"
+
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var webhookDao dao.WebhookStore
+
+const (
+	// webhookBatch is the number of deliveries attempted at once.
+	webhookBatch = 20
+	// The first retry of a delivery comes after webhookRetryBase, the delay
+	// doubles after every failed attempt up to webhookRetryMax.
+	webhookRetryBase = 10 * time.Second
+	webhookRetryMax  = time.Hour
+	// maxWebhookError bounds the error kept for the last attempt.
+	maxWebhookError = 500
+)
+
+type WebhookService struct {
+}
+
+// AddWebhook subscribes rawUrl to eventTypes. When secret is empty a random
+// one is generated.
+func (*WebhookService) AddWebhook(conf *config.Config, rawUrl string, eventTypes []string, secret string) (*model.Webhook, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, model.NewValidationError("Webhook url must be an absolute http or https URL! ")
+	}
+	if len(eventTypes) == 0 {
+		return nil, model.NewValidationError("Webhook needs at least one event type! ")
+	}
+	types := []string{}
+	seen := map[string]bool{}
+	for _, t := range eventTypes {
+		if !model.IsWebhookEventType(t) {
+			return nil, model.NewValidationError("Unknown webhook event type %s, expected one of %v! ", t, model.WebhookEventTypes)
+		}
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	if secret == "" {
+		b := make([]byte, 32)
+		rand.Read(b)
+		secret = hex.EncodeToString(b)
+	}
+	webhook := &model.Webhook{Url: rawUrl, EventTypes: types, Secret: secret, CreatedAt: time.Now()}
+	if err := webhookDao.AddWebhook(conf, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (*WebhookService) GetWebhooks(conf *config.Config) ([]model.Webhook, error) {
+	return webhookDao.GetWebhooks(conf)
+}
+
+// DeleteWebhook unsubscribes webhook id, its pending deliveries are dropped.
+func (*WebhookService) DeleteWebhook(conf *config.Config, id int64) error {
+	deleted, err := webhookDao.DeleteWebhook(conf, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return model.NewNotFoundError("Webhook %d does not exist", id)
+	}
+	return nil
+}
+
+// GetDeliveries returns one page of the deliveries with status, any status
+// when it is empty, from the newest one, and the id to continue from, which
+// is 0 on the last page.
+func (*WebhookService) GetDeliveries(conf *config.Config, status string, page model.Page) ([]model.WebhookDelivery, int64, error) {
+	switch model.DeliveryStatus(status) {
+	case "", model.DeliveryPending, model.DeliveryDelivered, model.DeliveryFailed:
+	default:
+		return nil, 0, model.NewValidationError("Bad parameter status, expected %s, %s or %s",
+			model.DeliveryPending, model.DeliveryDelivered, model.DeliveryFailed)
+	}
+	deliveries, err := webhookDao.GetDeliveries(conf, model.DeliveryStatus(status), model.Page{AfterId: page.AfterId, Limit: page.Limit + 1})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(deliveries) <= page.Limit {
+		return deliveries, 0, nil
+	}
+	deliveries = deliveries[:page.Limit]
+	return deliveries, deliveries[page.Limit-1].Id, nil
+}
+
+// ReplayDelivery attempts a failed delivery again, as soon as possible and
+// with a fresh count of attempts.
+func (*WebhookService) ReplayDelivery(conf *config.Config, id int64) (*model.WebhookDelivery, error) {
+	replayed, err := webhookDao.ReplayDelivery(conf, id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := webhookDao.GetDelivery(conf, id)
+	if err != nil {
+		return nil, err
+	}
+	if !replayed {
+		return nil, model.NewConflictError("Only failed deliveries can be replayed, delivery %d is %s! ", id, delivery.Status)
+	}
+	return delivery, nil
+}
+
+// webhookWorker delivers the webhooks in the background, it is started by
+// StartWebhookWorker.
+var webhookWorker struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartWebhookWorker starts delivering the pending webhook deliveries every
+// c.WebhookPollInterval seconds. Several instances can deliver at the same
+// time, a delivery is only attempted by one of them at once.
+func StartWebhookWorker(conf *config.Config) {
+	webhookWorker.stop, webhookWorker.done = make(chan struct{}), make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-webhookWorker.stop
+		cancel()
+	}()
+	go func() {
+		defer close(webhookWorker.done)
+		client := &http.Client{
+			Timeout: time.Duration(conf.WebhookTimeout) * time.Second,
+			// A redirect is an answer of the receiver, not a delivery.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+		ticker := time.NewTicker(time.Duration(conf.WebhookPollInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				deliverWebhooks(ctx, conf, client)
+			}
+		}
+	}()
+}
+
+// StopWebhookWorker stops the worker, the attempts in flight are abandoned
+// and made again once their claim expires.
+func StopWebhookWorker() {
+	if webhookWorker.stop == nil {
+		return
+	}
+	close(webhookWorker.stop)
+	<-webhookWorker.done
+	webhookWorker.stop = nil
+}
+
+// deliverWebhooks attempts the due deliveries, a batch at a time, until
+// none is left.
+func deliverWebhooks(ctx context.Context, conf *config.Config, client *http.Client) {
+	// Claims outlive the attempts of a batch, which run in parallel.
+	lease := 2*client.Timeout + time.Minute
+	for ctx.Err() == nil {
+		deliveries, err := webhookDao.ClaimDeliveries(conf, time.Now(), lease, webhookBatch)
+		if err != nil {
+			slog.Error("fail to claim webhook deliveries", "error", err.Error())
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+		webhooks, err := webhookDao.GetWebhooks(conf)
+		if err != nil {
+			slog.Error("fail to get webhooks", "error", err.Error())
+			return
+		}
+		byId := make(map[int64]*model.Webhook, len(webhooks))
+		for i := range webhooks {
+			byId[webhooks[i].Id] = &webhooks[i]
+		}
+		var wg sync.WaitGroup
+		for i := range deliveries {
+			webhook := byId[deliveries[i].Webhookid]
+			if webhook == nil {
+				// Deleted meanwhile, along with its deliveries.
+				continue
+			}
+			wg.Add(1)
+			go func(delivery *model.WebhookDelivery) {
+				defer wg.Done()
+				attemptDelivery(ctx, conf, client, webhook, delivery)
+			}(&deliveries[i])
+		}
+		wg.Wait()
+	}
+}
+
+// attemptDelivery posts delivery to webhook and records the outcome: a 2xx
+// answer delivers it, anything else is retried with an exponential backoff
+// until c.WebhookMaxAttempts attempts failed.
+func attemptDelivery(ctx context.Context, conf *config.Config, client *http.Client, webhook *model.Webhook, delivery *model.WebhookDelivery) {
+	status, err := postWebhook(ctx, client, webhook, delivery)
+	if ctx.Err() != nil {
+		return
+	}
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastStatus, delivery.LastError = status, ""
+	if err == nil {
+		delivery.Status, delivery.DeliveredAt = model.DeliveryDelivered, now
+		webhookDeliveriesTotal.Inc("delivered")
+	} else {
+		if delivery.LastError = err.Error(); len(delivery.LastError) > maxWebhookError {
+			delivery.LastError = delivery.LastError[:maxWebhookError]
+		}
+		if delivery.Attempts >= conf.WebhookMaxAttempts {
+			delivery.Status = model.DeliveryFailed
+			webhookDeliveriesTotal.Inc("failed")
+			slog.Error("webhook delivery failed", "delivery_id", delivery.Id, "webhook_id", webhook.Id,
+				"attempts", delivery.Attempts, "error", delivery.LastError)
+		} else {
+			delivery.NextAttemptAt = now.Add(webhookRetryDelay(delivery.Attempts))
+			webhookDeliveriesTotal.Inc("retried")
+			slog.Warn("webhook delivery attempt failed", "delivery_id", delivery.Id, "webhook_id", webhook.Id,
+				"attempts", delivery.Attempts, "error", delivery.LastError)
+		}
+	}
+	if err := webhookDao.UpdateDelivery(conf, delivery); err != nil {
+		slog.Error("fail to update webhook delivery", "delivery_id", delivery.Id, "error", err.Error())
+	}
+}
+
+// webhookRetryDelay returns the delay before the attempt following attempts
+// failed ones.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookRetryMax)
+}
+
+// postWebhook posts the payload of delivery and returns the status answered,
+// 0 when there was no answer. The signature header holds the hex HMAC-SHA256
+// of "<timestamp>.<payload>" keyed with the secret of the webhook, receivers
+// should check it and reject old timestamps.
+func postWebhook(ctx context.Context, client *http.Client, webhook *model.Webhook, delivery *model.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "simple-http-server-webhooks")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(delivery.Id, 10))
+	req.Header.Set("X-Webhook-Event", string(delivery.EventType))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(webhook.Secret, timestamp, delivery.Payload))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func signWebhook(secret string, timestamp string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/dao"
+	"github.com/tangyang/simple-http-server/model"
+
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the requests posted to it and answers them with
+// status.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   string
+}
+
+func (h *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests = append(h.requests, receivedWebhook{header: r.Header.Clone(), body: string(body)})
+	w.WriteHeader(h.status)
+}
+
+func (h *webhookReceiver) answer(status int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status = status
+}
+
+func (h *webhookReceiver) received() []receivedWebhook {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]receivedWebhook(nil), h.requests...)
+}
+
+// setupWebhook subscribes a receiver answering status to user.created on the
+// memory storage, adds a user and returns the delivery queued for it.
+func setupWebhook(t *testing.T, status int) (*config.Config, *webhookReceiver, *model.Webhook, *model.WebhookDelivery) {
+	conf := testConfigs(t)[dao.StorageMemory]
+	conf.WebhookTimeout, conf.WebhookMaxAttempts = 5, 3
+	initTestStorage(t, conf)
+	receiver := &webhookReceiver{status: status}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	webhook, err := (&WebhookService{}).AddWebhook(conf, server.URL, []string{string(model.WebhookUserCreated)}, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := addTestUser(t, conf, "hooked")
+	deliveries, err := webhookDao.GetDeliveries(conf, model.DeliveryPending, model.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d pending deliveries for user %d, want 1", len(deliveries), user.Id)
+	}
+	return conf, receiver, webhook, &deliveries[0]
+}
+
+// runWebhookWorker attempts the due deliveries once.
+func runWebhookWorker(conf *config.Config) {
+	deliverWebhooks(context.Background(), conf, &http.Client{Timeout: time.Duration(conf.WebhookTimeout) * time.Second})
+}
+
+// makeDue moves the next attempt of delivery id to the past.
+func makeDue(t *testing.T, conf *config.Config, id int64) {
+	delivery := getDelivery(t, conf, id)
+	delivery.NextAttemptAt = time.Now().Add(-time.Second)
+	if err := webhookDao.UpdateDelivery(conf, delivery); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getDelivery(t *testing.T, conf *config.Config, id int64) *model.WebhookDelivery {
+	delivery, err := webhookDao.GetDelivery(conf, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func TestWebhookDeliveryIsSigned(t *testing.T) {
+	conf, receiver, webhook, delivery := setupWebhook(t, http.StatusNoContent)
+	runWebhookWorker(conf)
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	req := requests[0]
+	timestamp := req.header.Get("X-Webhook-Timestamp")
+	if ts, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(ts, 0)) > time.Minute {
+		t.Errorf("bad timestamp header %q", timestamp)
+	}
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(timestamp + "." + req.body))
+	if got, want := req.header.Get("X-Webhook-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+	if got := req.header.Get("X-Webhook-Event"); got != string(model.WebhookUserCreated) {
+		t.Errorf("got event header %q, want %s", got, model.WebhookUserCreated)
+	}
+	if got := req.header.Get("X-Webhook-Id"); got != strconv.FormatInt(delivery.Id, 10) {
+		t.Errorf("got id header %q, want %d", got, delivery.Id)
+	}
+	var payload model.WebhookPayload
+	if err := json.Unmarshal([]byte(req.body), &payload); err != nil || payload.Type != model.WebhookUserCreated {
+		t.Errorf("got payload %s, error %v", req.body, err)
+	}
+
+	stored := getDelivery(t, conf, delivery.Id)
+	if stored.Status != model.DeliveryDelivered || stored.Attempts != 1 || stored.LastStatus != http.StatusNoContent {
+		t.Errorf("got delivery %+v, want delivered after 1 attempt", stored)
+	}
+}
+
+func TestWebhookRetryBacksOff(t *testing.T) {
+	conf, receiver, _, delivery := setupWebhook(t, http.StatusServiceUnavailable)
+	for attempt, delay := range []time.Duration{10 * time.Second, 20 * time.Second} {
+		start := time.Now()
+		runWebhookWorker(conf)
+		stored := getDelivery(t, conf, delivery.Id)
+		if stored.Status != model.DeliveryPending || stored.Attempts != attempt+1 || stored.LastStatus != http.StatusServiceUnavailable {
+			t.Fatalf("got delivery %+v after attempt %d, want pending", stored, attempt+1)
+		}
+		if next := stored.NextAttemptAt.Sub(start); next < delay || next > delay+time.Second {
+			t.Errorf("attempt %d is retried after %v, want %v", attempt+1, next, delay)
+		}
+		// Not due yet, nothing is posted.
+		runWebhookWorker(conf)
+		if got := len(receiver.received()); got != attempt+1 {
+			t.Fatalf("receiver got %d requests after attempt %d", got, attempt+1)
+		}
+		makeDue(t, conf, delivery.Id)
+	}
+
+	for attempts, want := range map[int]time.Duration{1: 10 * time.Second, 3: 40 * time.Second, 9: 2560 * time.Second, 30: time.Hour} {
+		if got := webhookRetryDelay(attempts); got != want {
+			t.Errorf("delay after %d attempts is %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestWebhookDeadLetterAndReplay(t *testing.T) {
+	conf, receiver, _, delivery := setupWebhook(t, http.StatusInternalServerError)
+	for i := 0; i < conf.WebhookMaxAttempts; i++ {
+		makeDue(t, conf, delivery.Id)
+		runWebhookWorker(conf)
+	}
+	stored := getDelivery(t, conf, delivery.Id)
+	if stored.Status != model.DeliveryFailed || stored.Attempts != conf.WebhookMaxAttempts {
+		t.Fatalf("got delivery %+v, want failed after %d attempts", stored, conf.WebhookMaxAttempts)
+	}
+	// Failed deliveries are never attempted again by themselves.
+	makeDue(t, conf, delivery.Id)
+	runWebhookWorker(conf)
+	if got := len(receiver.received()); got != conf.WebhookMaxAttempts {
+		t.Fatalf("receiver got %d requests, want %d", got, conf.WebhookMaxAttempts)
+	}
+
+	service := &WebhookService{}
+	replayed, err := service.ReplayDelivery(conf, delivery.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Status != model.DeliveryPending || replayed.Attempts != 0 {
+		t.Errorf("got replayed delivery %+v, want pending with no attempts", replayed)
+	}
+	receiver.answer(http.StatusOK)
+	runWebhookWorker(conf)
+	stored = getDelivery(t, conf, delivery.Id)
+	if stored.Status != model.DeliveryDelivered || stored.Attempts != 1 {
+		t.Errorf("got delivery %+v after replay, want delivered", stored)
+	}
+	if got := len(receiver.received()); got != conf.WebhookMaxAttempts+1 {
+		t.Errorf("receiver got %d requests, want %d", got, conf.WebhookMaxAttempts+1)
+	}
+
+	if _, err := service.ReplayDelivery(conf, delivery.Id); model.ErrorKindOf(err) != model.ErrorConflict {
+		t.Errorf("replaying a delivered delivery answered %v, want a conflict", err)
+	}
+}
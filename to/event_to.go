@@ -0,0 +1,32 @@
+package to
+
+import (
+	"github.com/tangyang/simple-http-server/model"
+	"time"
+)
+
+// EventTo is an event pushed to the requesting user, Type is the kind of
+// event and UserId the user on the other side.
+type EventTo struct {
+	Id        int64
+	Type      string
+	UserId    int64
+	MatchId   int64      `json:",omitempty"`
+	Message   *MessageTo `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+func NewEventTo(e *model.Event) *EventTo {
+	result := &EventTo{
+		Id:        e.Id,
+		Type:      string(e.Type),
+		UserId:    e.OtherUserId,
+		MatchId:   e.MatchId,
+		CreatedAt: e.CreatedAt.UTC(),
+	}
+	if e.Message != nil {
+		// Events are sent when the message is new, nobody read it yet.
+		result.Message = newMessageTo(e.Message, false)
+	}
+	return result
+}
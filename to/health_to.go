@@ -0,0 +1,29 @@
+package to
+
+type HealthTo struct {
+	Status string
+}
+
+// ReadinessTo is the body of the readiness probe. Status is "ready" when the
+// server should receive traffic, "not ready" otherwise; the other fields
+// tell why.
+type ReadinessTo struct {
+	Status              string
+	Accepting           bool
+	Storage             string
+	StorageStatus       string
+	StorageLatencyMs    float64
+	Pool                *PoolTo `json:",omitempty"`
+	SchemaVersion       int
+	LatestSchemaVersion int
+}
+
+// PoolTo describes the database connection pool, see ReadinessTo.
+type PoolTo struct {
+	Size       int
+	Requests   uint32
+	Hits       uint32
+	Timeouts   uint32
+	TotalConns uint32
+	FreeConns  uint32
+}
@@ -0,0 +1,78 @@
+package to
+
+import (
+	"github.com/tangyang/simple-http-server/model"
+	"time"
+)
+
+// MatchTo is an open match of the requesting user: UserId is the other
+// user, Id is the match id its messages are sent to.
+type MatchTo struct {
+	Id            int64
+	UserId        int64
+	CreatedAt     time.Time
+	LastMessageAt *time.Time `json:",omitempty"`
+	Unread        int
+	Type          string
+}
+
+// MessageTo is a message of a match, Read tells whether the user it was
+// sent to read it.
+type MessageTo struct {
+	Id        int64
+	MatchId   int64
+	SenderId  int64
+	Body      string
+	CreatedAt time.Time
+	Read      bool
+	Type      string
+}
+
+const (
+	matchType   = "match"
+	messageType = "message"
+)
+
+func NewMatchToArray(matches []model.Match, userId int64) []MatchTo {
+	result := []MatchTo{}
+	for _, match := range matches {
+		m := MatchTo{
+			Id:        match.Id,
+			UserId:    match.OtherMember(userId),
+			CreatedAt: match.CreatedAt.UTC(),
+			Unread:    match.Unread,
+			Type:      matchType,
+		}
+		if !match.LastMessageAt.IsZero() {
+			lastMessageAt := match.LastMessageAt.UTC()
+			m.LastMessageAt = &lastMessageAt
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+func NewMessageTo(message *model.Message, conversation *model.Conversation) *MessageTo {
+	recipient := conversation.OtherMember(message.Senderid)
+	return newMessageTo(message, conversation.LastReadBy(recipient) >= message.Id)
+}
+
+func newMessageTo(message *model.Message, read bool) *MessageTo {
+	return &MessageTo{
+		Id:        message.Id,
+		MatchId:   message.Conversationid,
+		SenderId:  message.Senderid,
+		Body:      message.Body,
+		CreatedAt: message.CreatedAt.UTC(),
+		Read:      read,
+		Type:      messageType,
+	}
+}
+
+func NewMessageToArray(messages []model.Message, conversation *model.Conversation) []MessageTo {
+	result := []MessageTo{}
+	for i := range messages {
+		result = append(result, *NewMessageTo(&messages[i], conversation))
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package to
+
+import (
+	"github.com/tangyang/simple-http-server/model"
+)
+
+// PhotoTo is a photo of a user, the first one is the primary photo. Url and
+// ThumbnailUrl are built from the photo-url-prefix setting.
+type PhotoTo struct {
+	Id           int64
+	Position     int
+	Primary      bool
+	Url          string
+	ThumbnailUrl string
+	Width        int
+	Height       int
+	Type         string
+}
+
+const (
+	photoType = "photo"
+)
+
+func NewPhotoTo(photo *model.Photo, urlPrefix string) *PhotoTo {
+	return &PhotoTo{
+		Id:           photo.Id,
+		Position:     photo.Position,
+		Primary:      photo.Position == 0,
+		Url:          urlPrefix + photo.Key,
+		ThumbnailUrl: urlPrefix + photo.ThumbnailKey,
+		Width:        photo.Width,
+		Height:       photo.Height,
+		Type:         photoType,
+	}
+}
+
+func NewPhotoToArray(photos []model.Photo, urlPrefix string) []PhotoTo {
+	result := []PhotoTo{}
+	for i := range photos {
+		result = append(result, *NewPhotoTo(&photos[i], urlPrefix))
+	}
+	return result
+}
@@ -4,28 +4,35 @@ import (
 	"github.com/tangyang/simple-http-server/model"
 )
 
+// RelationTo describes a relation from the point of view of the requesting
+// user: UserId is the other party, Direction tells whether the requesting
+// user made the swipe (outgoing) or received it (incoming).
 type RelationTo struct {
-	UserId int64
-	State  string
-	Type   string
+	UserId    int64
+	State     string
+	Direction string
+	Type      string
 }
 
 const (
 	relationType = "relationship"
 )
 
-func NewRelationTo(relation *model.Relation) *RelationTo {
+func NewRelationTo(relation *model.Relation, direction model.RelationDirection) *RelationTo {
 	relationDescription := string(relation.Status.ToRelationStatusDescription())
-	return &RelationTo{UserId: relation.Otheruserid, State: relationDescription, Type: relationType}
+	userId := relation.Otheruserid
+	if direction == model.RelationIncoming {
+		userId = relation.Userid
+	}
+	return &RelationTo{UserId: userId, State: relationDescription, Direction: string(direction), Type: relationType}
 }
 
-func NewRelationToArray(relations []model.Relation) []RelationTo {
+func NewRelationToArray(relations []model.Relation, direction model.RelationDirection) []RelationTo {
 	if relations != nil {
 		size := len(relations)
 		var result = []RelationTo{}
 		for i := 0; i < size; i++ {
-			relationDescription := string(relations[i].Status.ToRelationStatusDescription())
-			result = append(result, RelationTo{UserId: relations[i].Otheruserid, State: relationDescription, Type: relationType})
+			result = append(result, *NewRelationTo(&relations[i], direction))
 		}
 		return result
 	} else {
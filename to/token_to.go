@@ -0,0 +1,19 @@
+package to
+
+import (
+	"time"
+)
+
+type TokenTo struct {
+	Token     string
+	ExpiresAt time.Time
+	Type      string
+}
+
+const (
+	tokenType = "bearer"
+)
+
+func NewTokenTo(token string, expiresAt time.Time) *TokenTo {
+	return &TokenTo{Token: token, ExpiresAt: expiresAt.UTC(), Type: tokenType}
+}
@@ -2,12 +2,27 @@ package to
 
 import (
 	"github.com/tangyang/simple-http-server/model"
+	"time"
 )
 
+// UserTo is a user with its profile, the profile fields are omitted when
+// not filled in.
 type UserTo struct {
-	Id   int64
-	Name string
-	Type string
+	Id           int64
+	Name         string
+	Premium      bool
+	BirthDate    string     `json:",omitempty"`
+	Age          int        `json:",omitempty"`
+	Gender       string     `json:",omitempty"`
+	InterestedIn []string   `json:",omitempty"`
+	Bio          string     `json:",omitempty"`
+	LastActive   *time.Time `json:",omitempty"`
+	// DistanceKm is the distance to the requesting user rounded up to a
+	// distance bucket, the coordinates of users are never shown.
+	DistanceKm *int `json:",omitempty"`
+	// Photos are the photos of the user, the primary one first.
+	Photos []PhotoTo `json:",omitempty"`
+	Type   string
 }
 
 const (
@@ -15,7 +30,24 @@ const (
 )
 
 func NewUserTo(user *model.User) *UserTo {
-	return &UserTo{Id: user.Id, Name: user.Name, Type: userType}
+	u := &UserTo{
+		Id:           user.Id,
+		Name:         user.Name,
+		Premium:      user.Premium,
+		Gender:       user.Gender,
+		InterestedIn: user.InterestedIn,
+		Bio:          user.Bio,
+		Type:         userType,
+	}
+	if !user.BirthDate.IsZero() {
+		u.BirthDate = user.BirthDate.Format("2006-01-02")
+		u.Age = model.Age(user.BirthDate, time.Now())
+	}
+	if !user.LastActive.IsZero() {
+		lastActive := user.LastActive.UTC()
+		u.LastActive = &lastActive
+	}
+	return u
 }
 
 func NewUserToArray(users []model.User) []UserTo {
@@ -23,10 +55,45 @@ func NewUserToArray(users []model.User) []UserTo {
 		size := len(users)
 		var result = []UserTo{}
 		for i := 0; i < size; i++ {
-			result = append(result, UserTo{Id: users[i].Id, Name: users[i].Name, Type: userType})
+			result = append(result, *NewUserTo(&users[i]))
 		}
 		return result
 	} else {
 		return nil
 	}
 }
+
+// CandidateTo is a user suggested to the requesting user, LikesYou tells
+// whether the user already likes the requesting user.
+type CandidateTo struct {
+	UserTo
+	LikesYou bool
+}
+
+func NewCandidateToArray(candidates []model.Candidate) []CandidateTo {
+	result := []CandidateTo{}
+	for i := range candidates {
+		candidate := CandidateTo{UserTo: *NewUserTo(&candidates[i].User), LikesYou: candidates[i].LikesViewer}
+		if candidates[i].DistanceKm != nil {
+			candidate.DistanceKm = roundDistance(*candidates[i].DistanceKm)
+		}
+		result = append(result, candidate)
+	}
+	return result
+}
+
+// NewViewedUserTo is user as seen by a viewer distanceKm away, nil when the
+// distance is unknown.
+func NewViewedUserTo(user *model.User, distanceKm *float64) *UserTo {
+	u := NewUserTo(user)
+	if distanceKm != nil {
+		u.DistanceKm = roundDistance(*distanceKm)
+	}
+	return u
+}
+
+// roundDistance rounds km up to its bucket.
+func roundDistance(km float64) *int {
+	d := model.RoundDistanceKm(km)
+	return &d
+}
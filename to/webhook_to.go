@@ -0,0 +1,96 @@
+package to
+
+import (
+	"github.com/tangyang/simple-http-server/model"
+
+	"encoding/json"
+	"time"
+)
+
+// WebhookTo is a webhook subscription, its Secret is only shown when it is
+// created.
+type WebhookTo struct {
+	Id         int64
+	Url        string
+	EventTypes []string
+	Secret     string `json:",omitempty"`
+	CreatedAt  time.Time
+	Type       string
+}
+
+// WebhookDeliveryTo is one event posted to one webhook, Payload is the body
+// posted.
+type WebhookDeliveryTo struct {
+	Id            int64
+	WebhookId     int64
+	EventType     string
+	Status        string
+	Attempts      int
+	NextAttemptAt *time.Time `json:",omitempty"`
+	LastStatus    int
+	LastError     string
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time `json:",omitempty"`
+	Payload       json.RawMessage
+	Type          string
+}
+
+const (
+	webhookType         = "webhook"
+	webhookDeliveryType = "webhook_delivery"
+)
+
+func NewWebhookTo(webhook *model.Webhook, withSecret bool) *WebhookTo {
+	result := &WebhookTo{
+		Id:         webhook.Id,
+		Url:        webhook.Url,
+		EventTypes: webhook.EventTypes,
+		CreatedAt:  webhook.CreatedAt.UTC(),
+		Type:       webhookType,
+	}
+	if withSecret {
+		result.Secret = webhook.Secret
+	}
+	return result
+}
+
+func NewWebhookToArray(webhooks []model.Webhook) []WebhookTo {
+	result := []WebhookTo{}
+	for i := range webhooks {
+		result = append(result, *NewWebhookTo(&webhooks[i], false))
+	}
+	return result
+}
+
+func NewWebhookDeliveryTo(delivery *model.WebhookDelivery) *WebhookDeliveryTo {
+	result := &WebhookDeliveryTo{
+		Id:         delivery.Id,
+		WebhookId:  delivery.Webhookid,
+		EventType:  string(delivery.EventType),
+		Status:     string(delivery.Status),
+		Attempts:   delivery.Attempts,
+		LastStatus: delivery.LastStatus,
+		LastError:  delivery.LastError,
+		CreatedAt:  delivery.CreatedAt.UTC(),
+		Payload:    json.RawMessage(delivery.Payload),
+		Type:       webhookDeliveryType,
+	}
+	// Only pending deliveries are waiting for an attempt.
+	if delivery.Status == model.DeliveryPending {
+		next := delivery.NextAttemptAt.UTC()
+		result.NextAttemptAt = &next
+	}
+	if !delivery.DeliveredAt.IsZero() {
+		delivered := delivery.DeliveredAt.UTC()
+		result.DeliveredAt = &delivered
+	}
+	return result
+}
+
+func NewWebhookDeliveryToArray(deliveries []model.WebhookDelivery) []WebhookDeliveryTo {
+	result := []WebhookDeliveryTo{}
+	for i := range deliveries {
+		result = append(result, *NewWebhookDeliveryTo(&deliveries[i]))
+	}
+	return result
+}
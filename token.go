@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tangyang/simple-http-server/auth"
+	"github.com/tangyang/simple-http-server/config"
+	"github.com/tangyang/simple-http-server/service"
+	"strconv"
+)
+
+const tokenUsage = "usage: simple-http-server token USER_ID [admin]"
+
+// runToken executes the "token" sub command: it prints a token for a user,
+// which is the only way to get an admin token.
+func runToken(conf *config.Config, args []string) error {
+	if conf.AuthSecret == "" {
+		return errors.New("auth-secret is not set")
+	}
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != auth.RoleAdmin) {
+		return errors.New(tokenUsage)
+	}
+	userId, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return errors.New(tokenUsage)
+	}
+	role := auth.RoleUser
+	if len(args) == 2 {
+		role = auth.RoleAdmin
+	}
+	token, expiresAt := (&service.AuthService{}).IssueToken(conf, userId, role)
+	fmt.Println(token)
+	fmt.Printf("Expires at %s. \n", expiresAt.UTC().Format("2006-01-02 15:04:05 MST"))
+	return nil
+}
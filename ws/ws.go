@@ -0,0 +1,372 @@
+// Package ws implements the server side of the WebSocket protocol, RFC 6455,
+// on connections hijacked from net/http. Extensions and subprotocols are not
+// supported.
+package ws
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// acceptGuid is appended to the key of the client to compute the
+// Sec-WebSocket-Accept header.
+const acceptGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes, section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseInvalidData     = 1007
+	ClosePolicyViolation = 1008
+	CloseTooBig          = 1009
+	CloseInternalError   = 1011
+	CloseTryAgainLater   = 1013
+	closeNoStatus        = 1005
+)
+
+// maxControlPayload is the largest payload of control frames.
+const maxControlPayload = 125
+
+// HandshakeError is returned by Upgrade when the request is not a valid
+// opening handshake, Status is the HTTP status to answer with.
+type HandshakeError struct {
+	Status  int
+	Message string
+}
+
+func (e *HandshakeError) Error() string {
+	return e.Message
+}
+
+// CloseError is returned by ReadMessage once the connection is closed by
+// either side.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. ReadMessage must be called from a single
+// goroutine, the write methods can be called from any goroutine.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// MaxMessageSize bounds the messages read, larger ones close the
+	// connection with CloseTooBig.
+	MaxMessageSize int64
+	// OnPong is called with the payload of every pong received.
+	OnPong func(payload []byte)
+
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+// Upgrade completes the opening handshake of r and takes over its
+// connection. The headers already set on w are sent with the handshake
+// response. On a HandshakeError nothing is written to w.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		return nil, &HandshakeError{Status: http.StatusMethodNotAllowed, Message: "WebSocket handshake must be a GET request. "}
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return nil, &HandshakeError{Status: http.StatusBadRequest, Message: "Not a WebSocket handshake. "}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, &HandshakeError{Status: http.StatusUpgradeRequired, Message: "Only WebSocket version 13 is supported. "}
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		return nil, &HandshakeError{Status: http.StatusBadRequest, Message: "Bad Sec-WebSocket-Key header. "}
+	}
+	header := w.Header().Clone()
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	if brw.Reader.Buffered() > 0 {
+		// The client can not send frames before it got the handshake
+		// response.
+		conn.Close()
+		return nil, errors.New("websocket: data received before the handshake completed")
+	}
+
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n")
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			b.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.WriteString(conn, b.String()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Time{})
+	return &Conn{conn: conn, br: brw.Reader, MaxMessageSize: 64 * 1024}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGuid))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerContains reports whether the comma separated values of header name
+// hold token, compared case-insensitively.
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RemoteAddr returns the address of the client.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the time after which ReadMessage fails, the
+// connection can not be read anymore after that.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Close closes the underlying connection without a closing handshake.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// WriteText sends data as one text message, it fails when it can not be
+// written before deadline.
+func (c *Conn) WriteText(data []byte, deadline time.Time) error {
+	return c.writeFrame(OpText, data, deadline)
+}
+
+// WritePing sends a ping, the client answers with a pong.
+func (c *Conn) WritePing(payload []byte, deadline time.Time) error {
+	return c.writeFrame(opPing, payload, deadline)
+}
+
+// WriteClose starts the closing handshake with code and reason, nothing can
+// be written after it.
+func (c *Conn) WriteClose(code int, reason string, deadline time.Time) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return c.writeFrame(opClose, append(payload, reason...), deadline)
+}
+
+// writeFrame writes a single final frame, server frames are not masked.
+func (c *Conn) writeFrame(opcode byte, payload []byte, deadline time.Time) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return errors.New("websocket: close already sent")
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|opcode)
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+	c.conn.SetWriteDeadline(deadline)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+type frameHeader struct {
+	fin     bool
+	opcode  byte
+	length  int64
+	maskKey [4]byte
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs passed to OnPong on the way. Once the client closes the
+// connection, or breaks the protocol, the closing handshake is answered and
+// a *CloseError returned.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var opcode int
+	var message []byte
+	for {
+		h, err := c.readHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+		if h.opcode >= opClose {
+			if !h.fin || h.length > maxControlPayload {
+				return 0, nil, c.fail(CloseProtocolError, "bad control frame")
+			}
+			payload, err := c.readPayload(h)
+			if err != nil {
+				return 0, nil, err
+			}
+			if err := c.handleControl(h.opcode, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		switch {
+		case h.opcode == opContinuation && message == nil:
+			return 0, nil, c.fail(CloseProtocolError, "continuation without a message")
+		case h.opcode != opContinuation && message != nil:
+			return 0, nil, c.fail(CloseProtocolError, "message interleaved with a fragmented message")
+		case h.opcode != opContinuation && h.opcode != OpText && h.opcode != OpBinary:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+		if int64(len(message))+h.length > c.MaxMessageSize {
+			return 0, nil, c.fail(CloseTooBig, "message too big")
+		}
+		payload, err := c.readPayload(h)
+		if err != nil {
+			return 0, nil, err
+		}
+		if message == nil {
+			opcode = int(h.opcode)
+			message = make([]byte, 0, len(payload))
+		}
+		message = append(message, payload...)
+		if h.fin {
+			if opcode == OpText && !utf8.Valid(message) {
+				return 0, nil, c.fail(CloseInvalidData, "invalid UTF-8 text")
+			}
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *Conn) readHeader() (frameHeader, error) {
+	var h frameHeader
+	var b [8]byte
+	if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+		return h, err
+	}
+	if b[0]&0x70 != 0 {
+		return h, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if b[1]&0x80 == 0 {
+		return h, c.fail(CloseProtocolError, "client frames must be masked")
+	}
+	h.fin = b[0]&0x80 != 0
+	h.opcode = b[0] & 0x0f
+	h.length = int64(b[1] & 0x7f)
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+			return h, err
+		}
+		length := binary.BigEndian.Uint64(b[:8])
+		if length>>63 != 0 {
+			return h, c.fail(CloseProtocolError, "bad frame length")
+		}
+		h.length = int64(length)
+	}
+	if _, err := io.ReadFull(c.br, h.maskKey[:]); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// readPayload reads the payload of a frame whose length was checked, and
+// unmasks it.
+func (c *Conn) readPayload(h frameHeader) ([]byte, error) {
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= h.maskKey[i%4]
+	}
+	return payload, nil
+}
+
+func (c *Conn) handleControl(opcode byte, payload []byte) error {
+	deadline := time.Now().Add(10 * time.Second)
+	switch opcode {
+	case opPing:
+		return c.writeFrame(opPong, payload, deadline)
+	case opPong:
+		if c.OnPong != nil {
+			c.OnPong(payload)
+		}
+		return nil
+	case opClose:
+		code, reason := closeNoStatus, ""
+		if len(payload) == 1 {
+			return c.fail(CloseProtocolError, "bad close frame")
+		}
+		if len(payload) >= 2 {
+			code, reason = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+		}
+		// Echo the close frame, unless the server started the
+		// closing handshake.
+		if code == closeNoStatus {
+			c.writeFrame(opClose, nil, deadline)
+		} else {
+			c.WriteClose(code, "", deadline)
+		}
+		return &CloseError{Code: code, Reason: reason}
+	}
+	return c.fail(CloseProtocolError, "unknown control opcode")
+}
+
+// fail closes the connection with code after a protocol violation of the
+// client.
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason, time.Now().Add(time.Second))
+	return &CloseError{Code: code, Reason: reason}
+}